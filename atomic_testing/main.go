@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Failure injection harness for table writes. For insert, update and
+ * delete, and for the statements which insert, update or delete several
+ * rows at once, in both the text and the page format, each step of the
+ * write is failed in turn, first by returning an error and then by panicking to
+ * simulate a crash. After every failure the table must still open,
+ * recovering from its journal, and must read back as exactly the old or the
+ * new records.
+**/
+
+var errInjected error = errors.New("injected failure")
+
+var columns []table.Column = []table.Column{{Name: "SSN", Type: table.Integer}, {Name: "Name", Type: table.String}, {Name: "Married", Type: table.Boolean}}
+var rows [][]string = [][]string{{"123456789", "Scott John", "T"}, {"987654321", "Alex Scheel", "F"}, {"1827163", "Nicholas Scheel", "F"}}
+
+// The original unescaped format, which an insert upgrades with a rewrite.
+var legacy string = "[3][SSN:1][Name:4][Married:3][3]\n{123456789|Scott John|T}\n{987654321|Alex Scheel|F}\n{1827163|Nicholas Scheel|F}\n"
+
+type operation struct {
+	Name   string
+	Legacy bool
+	Format table.Format
+	Run    func(t *table.Table) error
+}
+
+var operations []operation = []operation{
+	{Name: "insert", Run: func(t *table.Table) error { return t.Insert([]string{"78786", "Jeff | Scheel", "T"}) }},
+	{Name: "delete", Run: func(t *table.Table) error { return t.Delete(1) }},
+	{Name: "upgrade", Legacy: true, Run: func(t *table.Table) error { return t.Insert([]string{"78786", "Jeff | Scheel", "T"}) }},
+	{Name: "page insert", Format: table.PageFormat, Run: func(t *table.Table) error { return t.Insert([]string{"78786", "Jeff | Scheel", "T"}) }},
+	{Name: "page delete", Format: table.PageFormat, Run: func(t *table.Table) error { return t.Delete(1) }},
+	{Name: "update", Run: func(t *table.Table) error { return t.Update(1, []string{"987654321", "Alex {Scheel}", "T"}) }},
+	{Name: "upgrade update", Legacy: true, Run: func(t *table.Table) error { return t.Update(1, []string{"987654321", "Alex {Scheel}", "T"}) }},
+	{Name: "page update", Format: table.PageFormat, Run: func(t *table.Table) error { return t.Update(1, []string{"987654321", "Alex {Scheel}", "T"}) }},
+	{Name: "insert rows", Run: insertRows},
+	{Name: "update where", Run: updateWhere},
+	{Name: "delete where", Run: deleteWhere},
+	{Name: "upgrade insert rows", Legacy: true, Run: insertRows},
+	{Name: "upgrade update where", Legacy: true, Run: updateWhere},
+	{Name: "upgrade delete where", Legacy: true, Run: deleteWhere},
+	{Name: "page insert rows", Format: table.PageFormat, Run: insertRows},
+	{Name: "page update where", Format: table.PageFormat, Run: updateWhere},
+	{Name: "page delete where", Format: table.PageFormat, Run: deleteWhere},
+}
+
+func insertRows(t *table.Table) error {
+	return t.InsertRows([][]string{{"78786", "Jeff | Scheel", "T"}, {"5", "Sam {Scheel}", "F"}})
+}
+
+// updateWhere and deleteWhere change the two rows with Scheel in the name.
+func updateWhere(t *table.Table) error {
+	q, err := t.ParseQuery("Name ~ 'Scheel'")
+	if err != nil {
+		return err
+	}
+
+	_, err = t.UpdateWhere(q, []int{1, 2}, []string{"A much longer name than before", "T"})
+	return err
+}
+
+func deleteWhere(t *table.Table) error {
+	q, err := t.ParseQuery("Name ~ 'Scheel'")
+	if err != nil {
+		return err
+	}
+
+	_, err = t.DeleteWhere(q)
+	return err
+}
+
+func setup(filename string, legacy_format bool, format table.Format) error {
+	os.Remove(filename)
+	os.Remove(filename + ".tmp")
+	os.Remove(filename + ".journal")
+
+	if legacy_format {
+		return ioutil.WriteFile(filename, []byte(legacy), 0666)
+	}
+
+	t, err := table.CreateWithFormat(filename, columns, format)
+	if err != nil {
+		return err
+	}
+
+	for i := range rows {
+		err = t.Insert(rows[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runOperation(filename string, op operation, fail_at int, crash bool) (step table.WriteStep, failed bool, err error) {
+	var calls int = 0
+
+	table.WriteHook = func(s table.WriteStep) error {
+		calls += 1
+		if calls != fail_at {
+			return nil
+		}
+
+		step = s
+		failed = true
+		if crash {
+			panic(errInjected)
+		}
+		return errInjected
+	}
+	defer func() { table.WriteHook = nil }()
+
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errInjected {
+				panic(r)
+			}
+			err = errInjected
+		}
+	}()
+
+	t, err := table.Open(filename)
+	if err != nil {
+		return step, failed, err
+	}
+
+	err = op.Run(t)
+	return step, failed, err
+}
+
+// contents returns the records of the table as a comparable string; the
+// table must open and every record must parse.
+func contents(filename string) (string, error) {
+	t, err := table.Open(filename)
+	if err != nil {
+		return "", err
+	}
+
+	all, err := t.Rows()
+	if err != nil {
+		return "", err
+	}
+	defer all.Close()
+
+	var result string
+	for all.Next() {
+		result += fmt.Sprintf("%d %q\n", all.Row().RID, all.Row().Values)
+	}
+
+	if all.Err() != nil {
+		return "", all.Err()
+	}
+
+	if t.Count() != strings.Count(result, "\n") {
+		return "", errors.New("record count " + strconv.Itoa(t.Count()) + " does not match records read")
+	}
+
+	return result, nil
+}
+
+func verify(filename string, before string, after string) error {
+	current, err := contents(filename)
+	if err != nil {
+		return err
+	}
+
+	if current != before && current != after {
+		return errors.New("table is neither the old nor the new version:\n" + current)
+	}
+
+	if _, err := os.Stat(filename + ".journal"); err == nil {
+		return errors.New("journal was not removed by recovery")
+	}
+
+	return nil
+}
+
+func main() {
+	dir, err := ioutil.TempDir("", "pet-atomic")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	var filename string = filepath.Join(dir, "atomic.tb")
+	var failures int = 0
+
+	for _, op := range operations {
+		// Run once without failures to learn the expected new contents.
+		err = setup(filename, op.Legacy, op.Format)
+		if err != nil {
+			fmt.Println("Setup failed:", err)
+			os.Exit(1)
+		}
+
+		before, err := contents(filename)
+		if err != nil {
+			fmt.Println("Setup failed:", err)
+			os.Exit(1)
+		}
+
+		_, _, err = runOperation(filename, op, 0, false)
+		if err != nil {
+			fmt.Println("Unexpected failure during", op.Name, ":", err)
+			os.Exit(1)
+		}
+
+		after, err := contents(filename)
+		if err != nil {
+			fmt.Println("Unexpected failure during", op.Name, ":", err)
+			os.Exit(1)
+		}
+
+		for _, crash := range []bool{false, true} {
+			for fail_at := 1; ; fail_at++ {
+				err = setup(filename, op.Legacy, op.Format)
+				if err != nil {
+					fmt.Println("Setup failed:", err)
+					os.Exit(1)
+				}
+
+				step, failed, err := runOperation(filename, op, fail_at, crash)
+				if !failed {
+					break
+				}
+
+				var mode string = "error"
+				if crash {
+					mode = "crash"
+				}
+
+				// A failure after the journal is written may be retried
+				// and succeed; then the table must hold the new records.
+				var expected_before string = before
+				if err == nil {
+					expected_before = after
+				}
+
+				err = verify(filename, expected_before, after)
+				if err != nil {
+					fmt.Println("FAIL", op.Name, mode, "at step", fail_at, "("+step.String()+"):", err)
+					failures += 1
+					continue
+				}
+
+				fmt.Println("ok  ", op.Name, mode, "at step", fail_at, "("+step.String()+")")
+			}
+		}
+	}
+
+	if failures > 0 {
+		fmt.Println(failures, "failures")
+		os.Exit(1)
+	}
+
+	fmt.Println("All injected failures left the table intact.")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+/**
+ * Search benchmark. Generates a table of -rows random rows in the original
+ * unescaped text format, which is quick to write, and times every query in
+ * queries twice: matching rows already in memory, which measures only the
+ * evaluation of the query, and a full search of the table file. Matching is
+ * repeated -repeat times and the fastest pass is reported.
+**/
+
+var columns []table.Column = []table.Column{{Name: "SSN", Type: table.Integer}, {Name: "Name", Type: table.String}, {Name: "Salary", Type: table.Double}, {Name: "Married", Type: table.Boolean}, {Name: "Bonus", Type: table.Integer}}
+
+var queries []string = []string{
+	"Salary > 50000",
+	"Name = 'Name 123'",
+	"Married = T & Salary > 90000 | Bonus > 5000",
+	"Salary >= 10000 & Salary <= 20000 & Married = F",
+	"SSN IN (1, 10, 100, 1000, 10000, 100000)",
+	"Name LIKE 'Name 1%' & NOT Married = T",
+	"Bonus + Salary * 12 >= 1000000",
+}
+
+func generate(filename string, rows int) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var writer *bufio.Writer = bufio.NewWriter(f)
+	fmt.Fprint(writer, "[", len(columns), "]")
+	for i := range columns {
+		fmt.Fprint(writer, "[", columns[i].Name, ":", int(columns[i].Type), "]")
+	}
+	fmt.Fprint(writer, "[", rows, "]\n")
+
+	var random *rand.Rand = rand.New(rand.NewSource(363))
+	for i := 0; i < rows; i++ {
+		var married string = "F"
+		if random.Intn(2) == 0 {
+			married = "T"
+		}
+
+		var salary string = strconv.FormatFloat(float64(random.Intn(10000000))/100, 'f', -1, 64)
+		fmt.Fprint(writer, "{", i, "|Name ", random.Intn(rows), "|", salary, "|", married, "|", random.Intn(10000), "}\n")
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func main() {
+	var rows int
+	var repeat int
+	flag.IntVar(&rows, "rows", 1000000, "number of rows in the generated table")
+	flag.IntVar(&repeat, "repeat", 3, "number of times to match every row against each query")
+	flag.Parse()
+
+	dir, err := ioutil.TempDir("", "pet-benchmark")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	var filename string = filepath.Join(dir, "benchmark.tb")
+	fmt.Println("Generating", rows, "rows...")
+	err = generate(filename, rows)
+	if err != nil {
+		fmt.Println("Generating failed:", err)
+		os.Exit(1)
+	}
+
+	t, err := table.Open(filename)
+	if err != nil {
+		fmt.Println("Open failed:", err)
+		os.Exit(1)
+	}
+
+	var values [][]string
+	all, err := t.Rows()
+	if err != nil {
+		fmt.Println("Read failed:", err)
+		os.Exit(1)
+	}
+	for all.Next() {
+		values = append(values, all.Row().Values)
+	}
+	all.Close()
+	if all.Err() != nil {
+		fmt.Println("Read failed:", all.Err())
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-50s %10s %12s %12s\n", "Query", "Matched", "Match ns/row", "Search")
+	for _, text := range queries {
+		q, err := t.ParseQuery(text)
+		if err != nil {
+			fmt.Println("Parse failed:", err)
+			os.Exit(1)
+		}
+
+		var matched int
+		var match_time time.Duration
+		for pass := 0; pass < repeat; pass++ {
+			matched = 0
+			var start time.Time = time.Now()
+			for i := range values {
+				ok, err := q.Match(values[i])
+				if err != nil {
+					fmt.Println("Match failed:", err)
+					os.Exit(1)
+				}
+				if ok {
+					matched += 1
+				}
+			}
+
+			if pass == 0 || time.Since(start) < match_time {
+				match_time = time.Since(start)
+			}
+		}
+
+		var start time.Time = time.Now()
+		result, err := t.Search(q)
+		if err != nil {
+			fmt.Println("Search failed:", err)
+			os.Exit(1)
+		}
+		for result.Next() {
+		}
+		result.Close()
+		if result.Err() != nil {
+			fmt.Println("Search failed:", result.Err())
+			os.Exit(1)
+		}
+		var search_time time.Duration = time.Since(start)
+
+		fmt.Printf("%-50s %10d %12d %12s\n", text, matched, match_time.Nanoseconds()/int64(len(values)), search_time.Round(time.Millisecond))
+	}
+}
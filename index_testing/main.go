@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * B+tree harness for table indexes. Rows with long string keys are inserted
+ * in random order until the index has split its leaves and its root several
+ * times over, and then most of them are deleted again and the rest updated.
+ * After every round, each query is run once through the indexes and checked
+ * against a full scan of the table, which must return exactly the same row
+ * ids. Last, an update whose index changes are lost, as in a crash, must
+ * leave the indexes stale: searches scan the table until the next update
+ * rebuilds them.
+**/
+
+var columns []table.Column = []table.Column{{Name: "Id", Type: table.Integer}, {Name: "Key", Type: table.String}, {Name: "Score", Type: table.Double}}
+
+// With keys this long, about 20 entries fit in a node, so the tree is three
+// levels deep once every row is in.
+var row_count int = 1500
+var padding string = strings.Repeat("x", 150)
+
+// key pads a number out to a long string, so that few fit in a node.
+func key(n int) string {
+	return fmt.Sprintf("key-%06d-", n) + padding
+}
+
+var queries []string = []string{
+	"Id = 17",
+	"Id >= 1250",
+	"Id < 40 | Id > 1460",
+	"Id BETWEEN 500 AND 600",
+	"Key = '" + key(1234) + "'",
+	"Key < '" + key(300) + "'",
+	"Key >= '" + key(1490) + "'",
+	"Score > 1400.5",
+	"Id IN (1, 500, 1234, 1499, 4000)",
+}
+
+func values(n int) []string {
+	return []string{strconv.Itoa(n), key(n), strconv.Itoa(n) + ".5"}
+}
+
+func insertRows(t *table.Table, numbers []int) error {
+	for _, n := range numbers {
+		err := t.Insert(values(n))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateRows gives every other live row the values of its number plus
+// shift, which moves it to another part of every index.
+func updateRows(t *table.Table, shift int) error {
+	rows, err := t.Rows()
+	if err != nil {
+		return err
+	}
+
+	var updates []table.Row
+	for i := 0; rows.Next(); i++ {
+		if i%2 == 0 {
+			updates = append(updates, rows.Row())
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for _, row := range updates {
+		n, err := strconv.Atoi(row.Values[0])
+		if err == nil {
+			err = t.Update(row.RID, values(n+shift))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loseIndexUpdate updates a row and then puts back the index files as they
+// were before, as if pet crashed before writing them.
+func loseIndexUpdate(t *table.Table, filename string, row_id int, n int) error {
+	names, err := filepath.Glob(filename + ".*.idx")
+	if err != nil {
+		return err
+	}
+
+	var saved [][]byte
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		saved = append(saved, data)
+	}
+
+	err = t.Update(row_id, values(n))
+	if err != nil {
+		return err
+	}
+
+	for i, name := range names {
+		err = ioutil.WriteFile(name, saved[i], 0666)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rowIDs returns the sorted row ids of the rows a query matches, and the
+// indexes the search used.
+func rowIDs(t *table.Table, query string, indexed bool) ([]int, []string, error) {
+	q, err := t.ParseQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows *table.Rows
+	if indexed {
+		rows, err = t.Search(q)
+	} else {
+		rows, err = t.Rows()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var result []int
+	for rows.Next() {
+		var row table.Row = rows.Row()
+		matched, err := q.Match(row.Values)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if matched {
+			result = append(result, row.RID)
+		}
+	}
+
+	sort.Ints(result)
+	return result, rows.Indexes(), rows.Err()
+}
+
+// check runs every query through the indexes and with a full scan. When
+// the indexes are stale, the search must not use them.
+func check(t *table.Table, round string, stale bool) int {
+	var failures int = 0
+
+	for _, query := range queries {
+		var shown string = strings.Replace(query, padding, "...", -1)
+
+		indexed, indexes, err := rowIDs(t, query, true)
+		if err == nil && !stale && len(indexes) == 0 {
+			err = errors.New("the search did not use an index")
+		} else if err == nil && stale && len(indexes) > 0 {
+			err = errors.New("the search used the stale indexes " + strings.Join(indexes, ", "))
+		}
+
+		var scanned []int
+		if err == nil {
+			scanned, _, err = rowIDs(t, query, false)
+		}
+
+		if err == nil && fmt.Sprint(indexed) != fmt.Sprint(scanned) {
+			err = errors.New("the index found " + strconv.Itoa(len(indexed)) + " rows but a scan found " + strconv.Itoa(len(scanned)))
+		}
+
+		if err != nil {
+			fmt.Println("FAIL", round, "`"+shown+"`:", err)
+			failures += 1
+			continue
+		}
+
+		fmt.Println("ok  ", round, "`"+shown+"`:", len(indexed), "rows")
+	}
+
+	return failures
+}
+
+func main() {
+	dir, err := ioutil.TempDir("", "pet-index")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	var failures int = 0
+	var random *rand.Rand = rand.New(rand.NewSource(363))
+
+	for _, format := range []table.Format{table.TextFormat, table.PageFormat} {
+		var filename string = filepath.Join(dir, format.String()+".tb")
+
+		t, err := table.CreateWithFormat(filename, columns, format)
+		if err == nil {
+			err = t.CreateIndex("Id")
+		}
+		if err == nil {
+			err = t.CreateIndex("Key")
+		}
+		if err == nil {
+			err = t.CreateIndex("Score")
+		}
+		if err != nil {
+			fmt.Println("Setup failed:", err)
+			os.Exit(1)
+		}
+
+		// Inserting in random order splits nodes in the middle of the tree
+		// rather than only at its right edge.
+		var numbers []int = random.Perm(row_count)
+		err = insertRows(t, numbers[:row_count/2])
+		if err != nil {
+			fmt.Println("Insert failed:", err)
+			os.Exit(1)
+		}
+		failures += check(t, format.String()+" half inserted", false)
+
+		err = insertRows(t, numbers[row_count/2:])
+		if err != nil {
+			fmt.Println("Insert failed:", err)
+			os.Exit(1)
+		}
+		failures += check(t, format.String()+" all inserted", false)
+
+		// Row ids follow the insert order; delete three rows in four, in
+		// random order, which leaves some leaves nearly or entirely empty.
+		for _, row_id := range random.Perm(row_count)[:row_count*3/4] {
+			err = t.Delete(row_id)
+			if err != nil {
+				fmt.Println("Delete failed:", err)
+				os.Exit(1)
+			}
+		}
+		failures += check(t, format.String()+" mostly deleted", false)
+
+		// Freed space in the leaves is used again.
+		err = insertRows(t, []int{17, 1234, 1499, 4000})
+		if err != nil {
+			fmt.Println("Insert failed:", err)
+			os.Exit(1)
+		}
+		failures += check(t, format.String()+" reinserted", false)
+
+		err = updateRows(t, 2*row_count)
+		if err != nil {
+			fmt.Println("Update failed:", err)
+			os.Exit(1)
+		}
+		failures += check(t, format.String()+" updated", false)
+
+		// Row 17 was reinserted, so it is live. In a page table this update
+		// fits in place and changes neither the counts nor the size.
+		first, _, err := rowIDs(t, "Id = 17", false)
+		if err == nil && len(first) != 1 {
+			err = errors.New("expected one row with Id 17 but found " + strconv.Itoa(len(first)))
+		}
+		if err == nil {
+			err = loseIndexUpdate(t, filename, first[0], 30)
+		}
+		if err != nil {
+			fmt.Println("Update failed:", err)
+			os.Exit(1)
+		}
+		failures += check(t, format.String()+" lost index update", true)
+
+		err = t.Update(first[0], values(17))
+		if err != nil {
+			fmt.Println("Update failed:", err)
+			os.Exit(1)
+		}
+		failures += check(t, format.String()+" rebuilt", false)
+	}
+
+	if failures > 0 {
+		fmt.Println(failures, "failures")
+		os.Exit(1)
+	}
+
+	fmt.Println("Every indexed search matched a full scan.")
+}
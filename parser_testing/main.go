@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+	"os"
+	"strings"
+)
+
+/**
+ * Error position harness for the query parser. Each malformed query must be
+ * rejected with a QueryError whose offset points at the offending character,
+ * or just past the end when the query stops short, and whose reason starts
+ * as given; none may panic. The caret drawn by Error is checked too, where
+ * tabs and multi-byte characters come before it.
+**/
+
+var columns []table.Column = []table.Column{{Name: "SSN", Type: table.Integer}, {Name: "Name", Type: table.String}, {Name: "Salary", Type: table.Double}, {Name: "Married", Type: table.Boolean}}
+
+type parserCase struct {
+	Query  string
+	Offset int
+	Reason string
+}
+
+var cases []parserCase = []parserCase{
+	{Query: "", Offset: 0, Reason: "empty query"},
+	{Query: "Name =", Offset: 6, Reason: "expected a value after `=`"},
+	{Query: "Salary >", Offset: 8, Reason: "expected a value after `>`"},
+	{Query: "Name = 'Bob", Offset: 7, Reason: "unterminated string"},
+	{Query: "Name = 'O''Brien", Offset: 7, Reason: "unterminated string"},
+	{Query: "(Salary > 5", Offset: 0, Reason: "unmatched `(`"},
+	{Query: "Salary > 5)", Offset: 10, Reason: "expected a join, ORDER BY"},
+	{Query: "Salary > 5 & ", Offset: 13, Reason: "expected a relation or `(`"},
+	{Query: "Salary > 5 Name = 'x'", Offset: 11, Reason: "expected a join, ORDER BY"},
+	{Query: "NOT", Offset: 3, Reason: "expected a relation or `(`"},
+	{Query: "SSN IN (1, 2", Offset: 12, Reason: "expected `,` or `)` in the list"},
+	{Query: "SSN BETWEEN 1", Offset: 13, Reason: "expected AND after `1`"},
+	{Query: "\tSalary @ 5", Offset: 8, Reason: "unknown character `@`"},
+	{Query: "Salary > 1.2.3", Offset: 9, Reason: "malformed number `1.2.3`"},
+	{Query: "SSN = 99999999999999999999", Offset: 6, Reason: "integer `99999999999999999999` is out of range"},
+	{Query: "Salry > 5", Offset: 0, Reason: "unknown column `Salry`"},
+	{Query: "100 < Salry", Offset: 6, Reason: "unknown column `Salry`"},
+	{Query: "1 < 2", Offset: 0, Reason: "expected a column name on either side of `<`"},
+	{Query: "Name = 'é' & Salry > 1", Offset: 14, Reason: "unknown column `Salry`"},
+	{Query: "Name > 5", Offset: 7, Reason: "column Name is not of numerical type"},
+	{Query: "Salary > 'x'", Offset: 9, Reason: "column Salary is of numerical type double"},
+	{Query: "SSN = 1.5", Offset: 6, Reason: "cannot convert `1.5` to integer"},
+	{Query: "Married < T", Offset: 8, Reason: "unknown operator for boolean columns: <"},
+	{Query: "Married = X", Offset: 10, Reason: "search value is not of boolean type: X"},
+	{Query: "Name LIKE Salary", Offset: 10, Reason: "cannot compare column Name of type string with column Salary"},
+	{Query: "Name ~ '('", Offset: 7, Reason: "invalid pattern"},
+	{Query: "Salary + Name > 1", Offset: 9, Reason: "column Name is of type string and cannot be used in arithmetic"},
+	{Query: "Name = 'Bob' ORDER BY Salry", Offset: 22, Reason: "unknown column `Salry`"},
+	{Query: "ORDER BY Name LIMIT x", Offset: 20, Reason: "expected a number of rows after LIMIT"},
+	{Query: "Name = 'x' LIMIT 5 LIMIT 5", Offset: 19, Reason: "LIMIT is given twice"},
+}
+
+// carets maps queries to the line Error draws under them.
+var carets map[string]string = map[string]string{
+	"\tSalary @ 5":           "    \t" + strings.Repeat(" ", 7) + "^",
+	"Name = 'é' & Salry > 1": "    " + strings.Repeat(" ", 13) + "^",
+}
+
+// parse returns the error of a query, turning a panic into a failure.
+func parse(query string) (err error, panicked interface{}) {
+	defer func() {
+		panicked = recover()
+	}()
+
+	_, err = table.ParseQuery(query, columns)
+	return err, nil
+}
+
+func check(c parserCase) string {
+	err, panicked := parse(c.Query)
+	if panicked != nil {
+		return fmt.Sprint("panicked: ", panicked)
+	} else if err == nil {
+		return "was accepted"
+	}
+
+	query_err, ok := err.(*table.QueryError)
+	if !ok {
+		return "returned a " + fmt.Sprintf("%T", err) + " rather than a QueryError: " + err.Error()
+	}
+
+	if query_err.Offset != c.Offset {
+		return fmt.Sprint("reported position ", query_err.Offset+1, " rather than ", c.Offset+1, ": ", query_err.Reason)
+	}
+
+	if !strings.HasPrefix(query_err.Reason, c.Reason) {
+		return "reported `" + query_err.Reason + "` rather than `" + c.Reason + "`"
+	}
+
+	if caret, ok := carets[c.Query]; ok {
+		var lines []string = strings.Split(err.Error(), "\n")
+		if lines[len(lines)-1] != caret {
+			return fmt.Sprintf("drew the caret as %q rather than %q", lines[len(lines)-1], caret)
+		}
+	}
+
+	return ""
+}
+
+func main() {
+	var failures int = 0
+
+	for _, c := range cases {
+		var problem string = check(c)
+		if problem != "" {
+			fmt.Printf("FAIL %q %s\n", c.Query, problem)
+			failures += 1
+			continue
+		}
+
+		fmt.Printf("ok   %q at position %d\n", c.Query, c.Offset+1)
+	}
+
+	if failures > 0 {
+		fmt.Println(failures, "failures")
+		os.Exit(1)
+	}
+
+	fmt.Println("Every malformed query was reported at the right position.")
+}
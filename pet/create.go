@@ -2,42 +2,50 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"github.com/chzyer/readline"
+	"github.com/cipherboy/coms363-pet/table"
 	"strconv"
+	"strings"
 )
 
-func TableCreate(attributes []string, types []int, filename string) {
+func TableCreate(columns []table.Column, filename string, format table.Format) {
 	fmt.Println("Call to create with:", filename)
-	var header string
-	header = "[" + strconv.Itoa(len(attributes)) + "]"
 
-	for i := range attributes {
-		header += "[" + attributes[i] + ":" + strconv.Itoa(types[i]) + "]"
-	}
-	header += "[0]\n"
-
-	if _, err := os.Stat(filename); err == nil {
+	_, err := table.CreateWithFormat(filename, columns, format)
+	if err == table.ErrExist {
 		fmt.Println("Error: file `", filename, "` already exists... Refusing to overwrite.")
 		return
-	}
-
-	f, err := os.Create(filename)
-	if err != nil {
-		fmt.Println("Error opening file:", err)
+	} else if err != nil {
+		fmt.Println("Error creating table:", err)
 		return
 	}
-	defer f.Close()
 
-	wl, err := f.Write([]byte(header))
-	if err != nil {
-		fmt.Println("Error writing file:", err)
-		return
-	}
+	fmt.Println("Successfully created table `", filename, "`!")
+}
 
-	if wl != len(header) {
-		fmt.Println("Error writing file: wrote", wl, "bytes but expected to write", len(header))
-		return
+// promptInteger asks for a number until one is given, such as the precision
+// of a decimal attribute.
+func promptInteger(prompt string) (int, error) {
+	for {
+		rl, err := readline.New(prompt)
+		if err != nil {
+			fmt.Println("Readline error:", err)
+			return 0, err
+		}
+
+		line, err := rl.Readline()
+		rl.Close()
+		if err != nil {
+			fmt.Println("Invalid input. Please try again.")
+			continue
+		}
+
+		result, err := strconv.Atoi(strings.Trim(line, " \n"))
+		if err != nil {
+			fmt.Println("Invalid character in number. Must be an integer.")
+			continue
+		}
+
+		return result, nil
 	}
-
-	fmt.Println("Successfully created table `", filename, "`!")
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+)
+
+func TableCreateIndex(column string, filename string) {
+	fmt.Println("Call to create index with:", filename, "and column", column)
+
+	t, err := openTable(filename)
+	if err != nil {
+		return
+	}
+
+	err = t.CreateIndex(column)
+	if err != nil {
+		fmt.Println("Error creating index:", err)
+		return
+	}
+
+	fmt.Println("Successfully created index on", column, "in table `", filename, "`!")
+}
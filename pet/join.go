@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+	"strings"
+)
+
+func TableJoin(options table.JoinOptions, selected []string, on string, where string, left_filename string, right_filename string) {
+	fmt.Println("Call to join with:", left_filename, "and", right_filename, "on", on)
+
+	left, err := openTable(left_filename)
+	if err != nil {
+		return
+	}
+
+	right, err := openTable(right_filename)
+	if err != nil {
+		return
+	}
+
+	fmt.Println("Parsing join: `" + on + "`")
+	if where != "" {
+		fmt.Println("Parsing query: `" + where + "`")
+	}
+
+	j, err := table.ParseJoin(on, where, left, right, options)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var columns []table.Column = j.Columns()
+	var positions []int
+	if len(selected) > 0 {
+		positions, err = j.SelectColumns(selected)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	} else {
+		for i := range columns {
+			positions = append(positions, i)
+		}
+	}
+
+	var shown []table.Column
+	for _, position := range positions {
+		shown = append(shown, columns[position])
+	}
+
+	fmt.Println("Evaluated Join:")
+	fmt.Println(j)
+	fmt.Println("Using a", j.Strategy())
+	if len(selected) > 0 {
+		fmt.Println("Showing columns:", strings.Join(selected, ", "))
+	}
+	fmt.Print("\n\n")
+
+	rows, err := j.Rows()
+	if err != nil {
+		fmt.Println("Fatal Error:", err)
+		return
+	}
+	defer rows.Close()
+
+	left_name, _ := j.Names()
+	var found int = 0
+
+	for rows.Next() {
+		var row = rows.Row()
+		var values []string
+		for _, position := range positions {
+			values = append(values, row.Values[position])
+		}
+
+		fmt.Println("====", left_name, "RID:", row.RID, "====")
+		printRow(shown, values)
+		fmt.Print("\n\n")
+		found += 1
+	}
+
+	if rows.Err() != nil {
+		fmt.Println("Fatal Error:", rows.Err())
+		return
+	}
+
+	fmt.Println("Joined rows: ", found)
+	fmt.Println("Successfully joined tables `", left_filename, "` and `", right_filename, "`!")
+}
@@ -1,17 +1,50 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/chzyer/readline"
+	"github.com/cipherboy/coms363-pet/table"
 	"strconv"
 	"strings"
 )
 
-var columnTypeToName map[int]string = map[int]string{1: "integer", 2: "double", 3: "boolean", 4: "string"}
+// openTable opens a table for one of the commands, printing any errors or
+// recoverable warnings.
+func openTable(filename string) (*table.Table, error) {
+	t, err := table.Open(filename)
+	if err == table.ErrNotExist {
+		fmt.Println("Error: file `", filename, "` does not exist...")
+		return nil, err
+	} else if err != nil {
+		fmt.Println("Fatal Error:", err)
+		return nil, err
+	}
+
+	if t.Warning() != nil {
+		fmt.Println("Recoverable Error:", t.Warning())
+	}
+
+	return t, nil
+}
 
 func main() {
-	prompt := []string{"pet> ", "Attribute name> ", "Valid attribute types:\n 1) Integer ;; 2) Double ;; 3) Boolean ;; 4) String\n\nType> ", "Additional attribute (y/n)> ", "rid> "}
-	help_text := "PET: PET Editing of Tables\n--------------------------\nBy Alexander Scheel\n\nCommands\n========\ncreate <filename>\t\t\t--\tcreates a database; prompts for attributes\nheader <filename>\t\t\t--\tdisplays attributes of a database\ninsert <filename>\t\t\t--\tinserts into a database; prompts for values\ndisplay <rid> <filename>\t\t--\tdisplays the <rid>th entry of the database\ndelete <rid> <filename>\t\t\t--\tdeletes the <rid>th entry of the database\nsearch \"<condition>\" <filename>\t\t--\tsearches for the given condition in the database.\nhelp\t\t\t\t\t--\tprints this help message\n\n\n"
+	var collation string
+	flag.DurationVar(&table.DefaultLockTimeout, "lock-timeout", table.DefaultLockTimeout, "how long to wait for another process to release a table")
+	flag.StringVar(&collation, "collation", table.DefaultQueryOptions.Collation.String(), "how search orders strings: binary, nocase or unicode")
+	flag.Int64Var(&table.SortMemory, "sort-memory", table.SortMemory, "how many bytes of rows a search with ORDER BY keeps in memory before spilling them to disk")
+	flag.BoolVar(&table.DefaultQueryOptions.IgnoreCase, "ignore-case", table.DefaultQueryOptions.IgnoreCase, "make =, != and IN ignore case when searching strings")
+	flag.Parse()
+
+	var err error
+	table.DefaultQueryOptions.Collation, err = table.ParseCollation(collation)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	prompt := []string{"pet> ", "Attribute name> ", "Valid attribute types:\n 1) Integer ;; 2) Double ;; 3) Boolean ;; 4) String ;; 5) Big integer ;; 6) Decimal\n\nType> ", "Additional attribute (y/n)> ", "rid> ", "Precision (digits in total)> ", "Scale (digits after the point)> "}
+	help_text := "PET: PET Editing of Tables\n--------------------------\nBy Alexander Scheel\n\nCommands\n========\ncreate <filename> [text|page]\t\t--\tcreates a database in the text (default) or binary page format; prompts for attributes\ncreate index <column> <filename>\t--\tcreates an index on an integer, double or string column, used by search\nheader <filename>\t\t\t--\tdisplays attributes of a database\ninsert <filename>\t\t\t--\tinserts into a database; prompts for values\ndisplay <rid> <filename>\t\t--\tdisplays the entry with row id <rid>\ndelete <rid> <filename>\t\t\t--\tdeletes the entry with row id <rid>; row ids are never reused\nsearch [columns] \"<condition>\" <filename>\t--\tsearches for the given condition in the database, showing only the comma-separated columns if given.\njoin [left] [columns] \"<on>\" [\"<query>\"] <left> <right>\t--\tjoins the rows of two databases meeting the condition <on>, such as abc.SSN = pay.SSN, keeping those matching <query>\n\nSQL statements\n==============\nCREATE TABLE <filename> (<column> <type>, ...) [FORMAT text|page]\nINSERT INTO <filename> [(<column>, ...)] VALUES (<value>, ...), ...\nSELECT *|<column>, ... FROM <filename> [[LEFT] JOIN <filename> ON <condition>] [WHERE <condition>]\nUPDATE <filename> SET <column> = <value>, ... [WHERE <condition>]\nDELETE FROM <filename> [WHERE <condition>]\nhelp\t\t\t\t\t--\tprints this help message\n\n\n"
 
 	var completer = readline.NewPrefixCompleter(
 		readline.PcItem("create"),
@@ -19,7 +52,10 @@ func main() {
 		readline.PcItem("display"),
 		readline.PcItem("header"),
 		readline.PcItem("insert"),
+		readline.PcItem("join"),
 		readline.PcItem("search"),
+		readline.PcItem("select"),
+		readline.PcItem("update"),
 		readline.PcItem("quit"),
 		readline.PcItem("exit"),
 		readline.PcItem("help"),
@@ -40,6 +76,11 @@ func main() {
 	for {
 		line, err := rl.Readline()
 		if err == nil {
+			if table.IsStatement(line) {
+				TableStatement(line)
+				continue
+			}
+
 			result := strings.Split(strings.ToLower(strings.Trim(line, " \t\n")), " ")
 			switch result[0] {
 			case "quit":
@@ -47,13 +88,34 @@ func main() {
 			case "exit":
 				return
 			case "create":
-				if len(result) != 2 {
-					fmt.Println("Error; invalid number of arguments to create: have", len(result), "but expected 2.")
+				if len(result) > 1 && result[1] == "index" {
+					// Column names are case sensitive, so take the column
+					// from the line as typed.
+					if len(result) != 4 {
+						fmt.Println("Error; invalid number of arguments to create index: have", len(result), "but expected 4.")
+						break
+					}
+
+					TableCreateIndex(strings.Fields(line)[2], result[3])
+					break
+				}
+
+				if len(result) != 2 && len(result) != 3 {
+					fmt.Println("Error; invalid number of arguments to create: have", len(result), "but expected 2 or 3.")
 					break
 				}
 
+				var format table.Format = table.TextFormat
+				if len(result) == 3 {
+					format, err = table.ParseFormat(result[2])
+					if err != nil {
+						fmt.Println("Error;", err)
+						break
+					}
+				}
+
 				var attribute_names []string
-				var attribute_types []int
+				var attribute_columns []table.Column
 
 				var stop bool = false
 
@@ -72,8 +134,8 @@ func main() {
 						if err == nil {
 							attribute_name = line2
 
-							if strings.Contains(attribute_name, ":") || strings.Contains(attribute_name, "[") || strings.Contains(attribute_name, "]") {
-								fmt.Println("Invalid character in attribute name. Invalid characters are ':', '['. and ']'.")
+							if err := table.ValidateColumnName(attribute_name); err != nil {
+								fmt.Println(err)
 								continue
 							} else {
 								var found bool = false
@@ -107,8 +169,8 @@ func main() {
 							tmp_string := line2
 							attribute_type, err = strconv.Atoi(tmp_string)
 
-							if err != nil || attribute_type < 1 || attribute_type > 4 {
-								fmt.Println("Invalid character in attribute type. Must be an integer, [1...4].")
+							if err != nil || attribute_type < 1 || attribute_type > 6 {
+								fmt.Println("Invalid character in attribute type. Must be an integer, [1...6].")
 								continue
 							} else {
 								break
@@ -118,8 +180,27 @@ func main() {
 						}
 					}
 
+					var column table.Column = table.Column{Name: attribute_name, Type: table.ColumnType(attribute_type)}
+					for column.Type == table.Decimal {
+						column.Precision, err = promptInteger(prompt[5])
+						if err != nil {
+							return
+						}
+
+						column.Scale, err = promptInteger(prompt[6])
+						if err != nil {
+							return
+						}
+
+						if err := table.ValidateColumnType(column); err != nil {
+							fmt.Println(err)
+							continue
+						}
+						break
+					}
+
 					attribute_names = append(attribute_names, attribute_name)
-					attribute_types = append(attribute_types, attribute_type)
+					attribute_columns = append(attribute_columns, column)
 
 					for {
 						rl2, err := readline.New(prompt[3])
@@ -147,7 +228,7 @@ func main() {
 					}
 				}
 
-				TableCreate(attribute_names, attribute_types, result[1])
+				TableCreate(attribute_columns, result[1], format)
 			case "header":
 				if len(result) != 2 {
 					fmt.Println("Error; invalid number of arguments to header: have", len(result), "but expected 2.")
@@ -249,7 +330,50 @@ func main() {
 					break
 				}
 
-				TableSearch(query[1], query[2])
+				// An optional list of columns to show comes before the
+				// query, as in `search Name,Salary "..." file`.
+				var selected []string
+				var words []string = strings.Fields(query[0])
+				if len(words) > 1 {
+					selected = strings.Split(strings.Join(words[1:], ""), ",")
+				}
+
+				TableSearch(selected, query[1], query[2])
+			case "join":
+				query := strings.Split(strings.Trim(line, " \t\n"), "\"")
+				if len(query) != 3 && len(query) != 5 {
+					fmt.Println("Error; invalid number of arguments to join: expected a quoted condition, an optional quoted query and two filenames.")
+					break
+				}
+
+				var files []string = strings.Fields(query[len(query)-1])
+				if len(files) != 2 || (len(query) == 5 && len(strings.Trim(query[2], " \t\n")) != 0) {
+					fmt.Println("Error; invalid filenames after join condition: expected two.")
+					break
+				}
+
+				var where string
+				if len(query) == 5 {
+					where = strings.Trim(query[3], " \t\n")
+				}
+
+				// LEFT or INNER and a list of columns to show may come before
+				// the condition, as in `join left Name,Amount "..." a b`.
+				var kind table.JoinKind = table.InnerJoin
+				var words []string = strings.Fields(query[0])[1:]
+				if len(words) > 0 && (strings.ToLower(words[0]) == "left" || strings.ToLower(words[0]) == "inner") {
+					if strings.ToLower(words[0]) == "left" {
+						kind = table.LeftJoin
+					}
+					words = words[1:]
+				}
+
+				var selected []string
+				if len(words) > 0 {
+					selected = strings.Split(strings.Join(words, ""), ",")
+				}
+
+				TableJoin(table.JoinOptions{Kind: kind, QueryOptions: table.DefaultQueryOptions}, selected, strings.Trim(query[1], " \t\n"), where, files[0], files[1])
 			case "help":
 				fmt.Print(help_text)
 			default:
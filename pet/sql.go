@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+)
+
+// TableStatement runs a SQL statement through the command it stands for,
+// such as `SELECT Name FROM abc.tb WHERE Salary > 100` through search.
+func TableStatement(line string) {
+	s, err := table.ParseStatement(line)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	switch s.Kind {
+	case table.CreateStatement:
+		TableCreate(s.Columns, s.Filename, s.Format)
+	case table.InsertStatement:
+		statementInsert(s)
+	case table.SelectStatement:
+		if s.Join == nil {
+			statementSelect(s)
+			break
+		}
+
+		var options table.JoinOptions = table.JoinOptions{Kind: s.Join.Kind, LeftName: s.Name, RightName: s.Join.Name, QueryOptions: table.DefaultQueryOptions}
+		TableJoin(options, s.Selected, s.Join.On, s.Query, s.Filename, s.Join.Filename)
+	case table.UpdateStatement:
+		statementUpdate(s)
+	case table.DeleteStatement:
+		statementDelete(s)
+	}
+}
+
+func statementInsert(s *table.Statement) {
+	fmt.Println("Call to insert with:", s.Filename)
+
+	t, err := openTable(s.Filename)
+	if err != nil {
+		return
+	}
+
+	// Every row is checked before any is inserted.
+	var columns []table.Column = t.Schema()
+	var rows [][]string
+	for i := range s.Rows {
+		row, err := s.Row(columns, s.Rows[i])
+		if err != nil {
+			fmt.Println("Error in row", i+1, ":", err)
+			return
+		}
+
+		for j := range columns {
+			row[j], err = table.ParseValue(columns[j], row[j])
+			if err != nil {
+				fmt.Println("Error in row", i+1, ":", err)
+				return
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	err = t.InsertRows(rows)
+	if err != nil {
+		fmt.Println("Fatal Error:", err)
+		return
+	}
+
+	fmt.Println("Inserted rows: ", len(rows))
+	fmt.Println("Successfully inserted into table `", s.Filename, "`!")
+}
+
+// statementSelect searches a table, or reads every row of it when the
+// statement has neither a WHERE nor any clauses.
+func statementSelect(s *table.Statement) {
+	if s.Query != "" {
+		TableSearch(s.Selected, s.Query, s.Filename)
+		return
+	}
+
+	fmt.Println("Call to search with:", s.Filename, "and every row")
+
+	t, err := openTable(s.Filename)
+	if err != nil {
+		return
+	}
+
+	searchQuery(t, t.MatchAll(), s.Selected, s.Filename)
+}
+
+// statementUpdate sets columns of the rows matching a query.
+func statementUpdate(s *table.Statement) {
+	fmt.Println("Call to update with:", s.Filename, "and query", s.Query)
+
+	t, err := openTable(s.Filename)
+	if err != nil {
+		return
+	}
+
+	positions, err := t.SelectColumns(s.Names)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	q, err := statementQuery(t, s.Query)
+	if err != nil {
+		return
+	}
+
+	count, err := t.UpdateWhere(q, positions, s.Values)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Updated rows: ", count)
+	fmt.Println("Successfully updated table `", s.Filename, "`!")
+}
+
+func statementDelete(s *table.Statement) {
+	fmt.Println("Call to delete with:", s.Filename, "and query", s.Query)
+
+	t, err := openTable(s.Filename)
+	if err != nil {
+		return
+	}
+
+	q, err := statementQuery(t, s.Query)
+	if err != nil {
+		return
+	}
+
+	count, err := t.DeleteWhere(q)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Deleted rows: ", count)
+	fmt.Println("Successfully deleted from table `", s.Filename, "`!")
+}
+
+// statementQuery parses the query of an UPDATE or DELETE, which matches
+// every row when it is empty.
+func statementQuery(t *table.Table, query string) (*table.Query, error) {
+	if query == "" {
+		return t.MatchAll(), nil
+	}
+
+	q, err := t.ParseQuery(query)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return q, err
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * External sort harness. SortMemory is made small enough that a sorted
+ * search spills many runs to disk and has to merge them more than once.
+ * Each ordered query, with and without LIMIT and OFFSET, must return the
+ * rows in the same order as sorting every matching row in memory. It must
+ * have spilled to disk unless its LIMIT keeps few enough rows, and every
+ * temporary file must be gone once the rows are closed.
+**/
+
+var columns []table.Column = []table.Column{{Name: "Id", Type: table.Integer}, {Name: "Group", Type: table.Integer}, {Name: "Score", Type: table.Double}, {Name: "Name", Type: table.String}, {Name: "Active", Type: table.Boolean}}
+
+var row_count int = 3000
+
+type sortCase struct {
+	Query  string
+	Less   func(a []string, b []string) bool
+	Offset int
+	Limit  int
+	Spills bool
+}
+
+func integer(value string) int {
+	result, _ := strconv.Atoi(value)
+	return result
+}
+
+func double(value string) float64 {
+	result, _ := strconv.ParseFloat(value, 64)
+	return result
+}
+
+var byGroupThenScoreDesc = func(a []string, b []string) bool {
+	if integer(a[1]) != integer(b[1]) {
+		return integer(a[1]) < integer(b[1])
+	}
+	return double(a[2]) > double(b[2])
+}
+
+var byName = func(a []string, b []string) bool {
+	return a[3] < b[3]
+}
+
+var byActiveThenId = func(a []string, b []string) bool {
+	if a[4] != b[4] {
+		return a[4] == "F"
+	}
+	return integer(a[0]) < integer(b[0])
+}
+
+var cases []sortCase = []sortCase{
+	{Query: "ORDER BY Group, Score DESC", Less: byGroupThenScoreDesc, Limit: -1, Spills: true},
+	{Query: "ORDER BY Name", Less: byName, Limit: -1, Spills: true},
+	{Query: "Score > 25 ORDER BY Active, Id", Less: byActiveThenId, Limit: -1, Spills: true},
+	{Query: "ORDER BY Group, Score DESC LIMIT 25 OFFSET 1000", Less: byGroupThenScoreDesc, Offset: 1000, Limit: 25, Spills: true},
+	{Query: "ORDER BY Name OFFSET 2990", Less: byName, Offset: 2990, Limit: -1, Spills: true},
+	{Query: "ORDER BY Name LIMIT 3", Less: byName, Limit: 3, Spills: false},
+}
+
+// expected sorts every matching row in memory. The sort is stable, as ties
+// keep their file order.
+func expected(t *table.Table, c sortCase) ([]string, error) {
+	var condition string = strings.TrimSpace(c.Query[:strings.Index(c.Query, "ORDER BY")])
+	var q *table.Query
+	if condition != "" {
+		var err error
+		q, err = t.ParseQuery(condition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := t.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matched []table.Row
+	for rows.Next() {
+		var row table.Row = rows.Row()
+		if q != nil {
+			ok, err := q.Match(row.Values)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+		matched = append(matched, row)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	sort.SliceStable(matched, func(i int, j int) bool { return c.Less(matched[i].Values, matched[j].Values) })
+
+	var result []string
+	for i := c.Offset; i < len(matched) && (c.Limit == -1 || i < c.Offset+c.Limit); i++ {
+		result = append(result, strconv.Itoa(matched[i].RID))
+	}
+	return result, nil
+}
+
+// sorted returns the row ids a sorted search returns, and how many runs it
+// had on disk once it started returning rows.
+func sorted(t *table.Table, query string, temp string) ([]string, int, error) {
+	q, err := t.ParseQuery(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := t.Search(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var result []string
+	var runs []string
+	for rows.Next() {
+		if len(result) == 0 {
+			runs, err = filepath.Glob(filepath.Join(temp, "pet-sort*"))
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		result = append(result, strconv.Itoa(rows.Row().RID))
+	}
+	return result, len(runs), rows.Err()
+}
+
+func check(t *table.Table, c sortCase, temp string, spills bool) error {
+	want, err := expected(t, c)
+	if err != nil {
+		return err
+	}
+
+	got, runs, err := sorted(t, c.Query, temp)
+	if err != nil {
+		return err
+	}
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		return errors.New("got " + strconv.Itoa(len(got)) + " rows in a different order than the " + strconv.Itoa(len(want)) + " expected")
+	}
+
+	if spills && runs == 0 {
+		return errors.New("the sort never spilled to disk")
+	} else if !spills && runs > 0 {
+		return errors.New("the sort spilled " + strconv.Itoa(runs) + " runs to disk")
+	}
+
+	left, err := filepath.Glob(filepath.Join(temp, "pet-sort*"))
+	if err != nil {
+		return err
+	} else if len(left) > 0 {
+		return errors.New(strconv.Itoa(len(left)) + " temporary files were left behind")
+	}
+
+	return nil
+}
+
+func main() {
+	dir, err := ioutil.TempDir("", "pet-sort-testing")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	// Runs go to a directory of their own, so that leftovers can be found.
+	var temp string = filepath.Join(dir, "temp")
+	err = os.Mkdir(temp, 0777)
+	if err == nil {
+		err = os.Setenv("TMPDIR", temp)
+	}
+	if err != nil {
+		fmt.Println("Setup failed:", err)
+		os.Exit(1)
+	}
+
+	t, err := table.Create(filepath.Join(dir, "sort.tb"), columns)
+	if err != nil {
+		fmt.Println("Setup failed:", err)
+		os.Exit(1)
+	}
+
+	// Few groups and few distinct scores make many ties, which must keep
+	// their file order across runs.
+	var random *rand.Rand = rand.New(rand.NewSource(363))
+	for i := 0; i < row_count; i++ {
+		var active string = "F"
+		if random.Intn(2) == 0 {
+			active = "T"
+		}
+
+		var values []string = []string{strconv.Itoa(i), strconv.Itoa(random.Intn(7)), strconv.Itoa(random.Intn(50)) + ".5", fmt.Sprintf("name %04d", random.Intn(row_count/2)), active}
+		err = t.Insert(values)
+		if err != nil {
+			fmt.Println("Setup failed:", err)
+			os.Exit(1)
+		}
+	}
+
+	var failures int = 0
+
+	// About a dozen rows fit in memory, so a full sort writes a few hundred
+	// runs and merges them every max_sort_runs.
+	for _, memory := range []int64{64 << 20, 2048} {
+		table.SortMemory = memory
+
+		for _, c := range cases {
+			err = check(t, c, temp, c.Spills && memory < 64<<20)
+			if err != nil {
+				fmt.Println("FAIL", memory, "bytes `"+c.Query+"`:", err)
+				failures += 1
+				continue
+			}
+
+			fmt.Println("ok  ", memory, "bytes `"+c.Query+"`")
+		}
+	}
+
+	if failures > 0 {
+		fmt.Println(failures, "failures")
+		os.Exit(1)
+	}
+
+	fmt.Println("Every sorted search matched an in-memory sort.")
+}
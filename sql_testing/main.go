@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cipherboy/coms363-pet/table"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * SQL statement harness. Each statement must parse into the fields of the
+ * command it stands for, and each malformed one must be rejected with a
+ * QueryError whose reason starts as given. A script of statements is then
+ * run against a table in both formats, as pet runs them, and the rows left
+ * after each statement are checked, row ids and all: an UPDATE must keep
+ * the row id of every row it changes.
+**/
+
+type parseCase struct {
+	Statement string
+	Want      string
+}
+
+var parseCases []parseCase = []parseCase{
+	{Statement: "CREATE TABLE abc.tb (SSN integer, Name string, Pay decimal(8,2))", Want: "CREATE TABLE abc.tb columns=[SSN integer, Name string, Pay decimal(8,2)] format=text"},
+	{Statement: "create table '../tables/abc.tb' (Id bigint) format page;", Want: "CREATE TABLE ../tables/abc.tb columns=[Id bigint] format=page"},
+	{Statement: "INSERT INTO abc.tb VALUES (1, 'Ann', 10.50), (2, 'Bob', -3)", Want: "INSERT abc.tb rows=[[1 Ann 10.50] [2 Bob -3]]"},
+	{Statement: "INSERT INTO abc.tb (Name, SSN) VALUES ('Cy', 3)", Want: "INSERT abc.tb names=[Name SSN] rows=[[Cy 3]]"},
+	{Statement: "INSERT INTO abc.tb VALUES (4, 'O''Brien', ''''), (5, '', 1)", Want: "INSERT abc.tb rows=[[4 O'Brien '] [5  1]]"},
+	{Statement: "SELECT * FROM abc.tb", Want: "SELECT abc.tb"},
+	{Statement: "SELECT Name, COUNT(*) FROM abc.tb WHERE Pay > 2 GROUP BY Name", Want: "SELECT abc.tb selected=[Name COUNT(*)] query=`Pay > 2 GROUP BY Name`"},
+	{Statement: "SELECT Name FROM abc.tb ORDER BY Name LIMIT 1", Want: "SELECT abc.tb selected=[Name] query=`ORDER BY Name LIMIT 1`"},
+	{Statement: "SELECT a.Name FROM abc.tb AS a LEFT JOIN '../pay.tb' p ON a.SSN = p.SSN WHERE p.Month = 1", Want: "SELECT abc.tb name=a selected=[a.Name] join=left ../pay.tb p `a.SSN = p.SSN` query=`p.Month = 1`"},
+	{Statement: "SELECT * FROM abc.tb JOIN pay.tb ON SSN = SSN", Want: "SELECT abc.tb join=inner pay.tb  `SSN = SSN`"},
+	{Statement: "UPDATE abc.tb SET Pay = 4, Name = 'Zed' WHERE SSN = 2", Want: "UPDATE abc.tb names=[Pay Name] values=[4 Zed] query=`SSN = 2`"},
+	{Statement: "update abc.tb set Married = T", Want: "UPDATE abc.tb names=[Married] values=[T]"},
+	{Statement: "DELETE FROM abc.tb WHERE Name = 'Ann' | SSN > 5", Want: "DELETE abc.tb query=`Name = 'Ann' | SSN > 5`"},
+	{Statement: "DELETE FROM abc.tb;", Want: "DELETE abc.tb"},
+	{Statement: "DELETE FROM abc.tb WHERE Name = 'O''Brien'", Want: "DELETE abc.tb query=`Name = 'O''Brien'`"},
+}
+
+type errorCase struct {
+	Statement string
+	Reason    string
+}
+
+var errorCases []errorCase = []errorCase{
+	{Statement: "DROP TABLE abc.tb", Reason: "expected CREATE TABLE, INSERT, SELECT, UPDATE or DELETE"},
+	{Statement: "CREATE TABLE abc.tb (Id int)", Reason: "unknown type `int`"},
+	{Statement: "INSERT INTO abc.tb VALUES (1, 2", Reason: "expected `)`"},
+	{Statement: "INSERT INTO abc.tb VALUES (1, 'it''s)", Reason: "unterminated string"},
+	{Statement: "SELECT FROM abc.tb", Reason: "expected `*`, a column or an aggregate"},
+	{Statement: "SELECT * FROM abc.tb x", Reason: "only the tables of a join can be named"},
+	{Statement: "UPDATE abc.tb SET Pay 4", Reason: "expected `=`"},
+	{Statement: "DELETE FROM abc.tb WHERE", Reason: "expected a condition"},
+}
+
+// describe lists the fields of a statement which are set.
+func describe(s *table.Statement) string {
+	var result []string = []string{s.Kind.String(), s.Filename}
+	if s.Name != "" {
+		result = append(result, "name="+s.Name)
+	}
+	if s.Columns != nil {
+		var columns []string
+		for _, column := range s.Columns {
+			var kind string = column.Type.String()
+			if column.Type == table.Decimal {
+				kind += "(" + strconv.Itoa(column.Precision) + "," + strconv.Itoa(column.Scale) + ")"
+			}
+			columns = append(columns, column.Name+" "+kind)
+		}
+		result = append(result, "columns=["+strings.Join(columns, ", ")+"]", "format="+s.Format.String())
+	}
+	if s.Selected != nil {
+		result = append(result, fmt.Sprint("selected=", s.Selected))
+	}
+	if s.Join != nil {
+		result = append(result, "join="+s.Join.Kind.String(), s.Join.Filename, s.Join.Name, "`"+s.Join.On+"`")
+	}
+	if s.Names != nil {
+		result = append(result, fmt.Sprint("names=", s.Names))
+	}
+	if s.Rows != nil {
+		result = append(result, fmt.Sprint("rows=", s.Rows))
+	}
+	if s.Values != nil {
+		result = append(result, fmt.Sprint("values=", s.Values))
+	}
+	if s.Query != "" {
+		result = append(result, "query=`"+s.Query+"`")
+	}
+	return strings.Join(result, " ")
+}
+
+func checkParse(c parseCase) string {
+	s, err := table.ParseStatement(c.Statement)
+	if err != nil {
+		return "was rejected: " + err.Error()
+	}
+
+	var got string = describe(s)
+	if got != c.Want {
+		return "parsed as `" + got + "` rather than `" + c.Want + "`"
+	}
+	return ""
+}
+
+func checkError(c errorCase) string {
+	_, err := table.ParseStatement(c.Statement)
+	if err == nil {
+		return "was accepted"
+	}
+
+	query_err, ok := err.(*table.QueryError)
+	if !ok {
+		return "returned a " + fmt.Sprintf("%T", err) + " rather than a QueryError: " + err.Error()
+	} else if !strings.HasPrefix(query_err.Reason, c.Reason) {
+		return "reported `" + query_err.Reason + "` rather than `" + c.Reason + "`"
+	}
+	return ""
+}
+
+type step struct {
+	Statement string
+	Rows      string
+}
+
+// script is run once for each format; Rows lists the rows of the table
+// after each statement as <row id>:<values>.
+var script []step = []step{
+	{Statement: "CREATE TABLE people.tb (Id integer, Name string, Pay decimal(8,2)) FORMAT %s", Rows: ""},
+	{Statement: "INSERT INTO people.tb VALUES (1, 'Ann', 10.5), (2, 'Bob', 3)", Rows: "0:1,Ann,10.50 1:2,Bob,3.00"},
+	{Statement: "INSERT INTO people.tb (Name, Pay, Id) VALUES ('Cy', 7.25, 3)", Rows: "0:1,Ann,10.50 1:2,Bob,3.00 2:3,Cy,7.25"},
+	{Statement: "UPDATE people.tb SET Pay = 4, Name = 'Bo' WHERE Id = 2", Rows: "0:1,Ann,10.50 1:2,Bo,4.00 2:3,Cy,7.25"},
+	{Statement: "UPDATE people.tb SET Name = 'Big' WHERE Pay > 5", Rows: "0:1,Big,10.50 1:2,Bo,4.00 2:3,Big,7.25"},
+	{Statement: "DELETE FROM people.tb WHERE Name = 'Big' & Id = 1", Rows: "1:2,Bo,4.00 2:3,Big,7.25"},
+	{Statement: "UPDATE people.tb SET Pay = 1", Rows: "1:2,Bo,1.00 2:3,Big,1.00"},
+	{Statement: "INSERT INTO people.tb VALUES (4, 'Di', 2)", Rows: "1:2,Bo,1.00 2:3,Big,1.00 3:4,Di,2.00"},
+	{Statement: "INSERT INTO people.tb VALUES (5, 'O''Brien', 2)", Rows: "1:2,Bo,1.00 2:3,Big,1.00 3:4,Di,2.00 4:5,O'Brien,2.00"},
+	{Statement: "UPDATE people.tb SET Name = 'D''Arcy' WHERE Name = 'O''Brien'", Rows: "1:2,Bo,1.00 2:3,Big,1.00 3:4,Di,2.00 4:5,D'Arcy,2.00"},
+	{Statement: "DELETE FROM people.tb", Rows: ""},
+}
+
+// parse parses the query of a statement, which matches every row when it
+// is empty.
+func parse(t *table.Table, query string) (*table.Query, error) {
+	if query == "" {
+		return t.MatchAll(), nil
+	}
+	return t.ParseQuery(query)
+}
+
+// matching reads every row a query matches, or every row when it is empty.
+func matching(t *table.Table, query string) ([]table.Row, error) {
+	q, err := parse(t, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := t.Search(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []table.Row
+	for rows.Next() {
+		result = append(result, rows.Row())
+	}
+	return result, rows.Err()
+}
+
+// run carries out a statement the way pet does.
+func run(s *table.Statement) error {
+	if s.Kind == table.CreateStatement {
+		t, err := table.CreateWithFormat(s.Filename, s.Columns, s.Format)
+		if err == nil {
+			err = t.CreateIndex("Name")
+		}
+		return err
+	}
+
+	t, err := table.Open(s.Filename)
+	if err != nil {
+		return err
+	}
+
+	var columns []table.Column = t.Schema()
+
+	switch s.Kind {
+	case table.InsertStatement:
+		var rows [][]string
+		for i := range s.Rows {
+			row, err := s.Row(columns, s.Rows[i])
+			if err != nil {
+				return err
+			}
+
+			for j := range columns {
+				row[j], err = table.ParseValue(columns[j], row[j])
+				if err != nil {
+					return err
+				}
+			}
+
+			rows = append(rows, row)
+		}
+
+		return t.InsertRows(rows)
+	case table.UpdateStatement:
+		positions, err := t.SelectColumns(s.Names)
+		if err != nil {
+			return err
+		}
+
+		q, err := parse(t, s.Query)
+		if err != nil {
+			return err
+		}
+
+		_, err = t.UpdateWhere(q, positions, s.Values)
+		return err
+	case table.DeleteStatement:
+		q, err := parse(t, s.Query)
+		if err != nil {
+			return err
+		}
+
+		_, err = t.DeleteWhere(q)
+		return err
+	default:
+		return errors.New("the script cannot run a " + s.Kind.String() + " statement")
+	}
+}
+
+// contents lists the rows of a table by row id, since an update moves its
+// row to the end of the file or page, and checks that a search through the
+// index on Name finds each of them by its name.
+func contents(filename string) (string, error) {
+	t, err := table.Open(filename)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := matching(t, "")
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(rows, func(i int, j int) bool { return rows[i].RID < rows[j].RID })
+
+	var result []string
+	for _, row := range rows {
+		found, err := matching(t, "Name = '"+strings.Replace(row.Values[1], "'", "''", -1)+"'")
+		if err != nil {
+			return "", err
+		}
+
+		var indexed bool = false
+		for _, other := range found {
+			indexed = indexed || other.RID == row.RID
+		}
+		if !indexed {
+			return "", errors.New("the index on Name does not find row " + strconv.Itoa(row.RID))
+		}
+
+		result = append(result, strconv.Itoa(row.RID)+":"+strings.Join(row.Values, ","))
+	}
+	return strings.Join(result, " "), nil
+}
+
+func checkScript(format table.Format) int {
+	var failures int = 0
+
+	for _, st := range script {
+		var text string = strings.Replace(st.Statement, "%s", format.String(), -1)
+
+		s, err := table.ParseStatement(text)
+		if err == nil {
+			err = run(s)
+		}
+
+		var got string
+		if err == nil {
+			got, err = contents("people.tb")
+		}
+
+		if err == nil && got != st.Rows {
+			err = errors.New("left `" + got + "` rather than `" + st.Rows + "`")
+		}
+
+		if err != nil {
+			fmt.Printf("FAIL %s %q: %v\n", format.String(), text, err)
+			failures += 1
+			continue
+		}
+
+		fmt.Printf("ok   %s %q\n", format.String(), text)
+	}
+
+	return failures
+}
+
+func main() {
+	var failures int = 0
+
+	for _, c := range parseCases {
+		var problem string = checkParse(c)
+		if problem != "" {
+			fmt.Printf("FAIL %q %s\n", c.Statement, problem)
+			failures += 1
+			continue
+		}
+
+		fmt.Printf("ok   %q\n", c.Statement)
+	}
+
+	for _, c := range errorCases {
+		var problem string = checkError(c)
+		if problem != "" {
+			fmt.Printf("FAIL %q %s\n", c.Statement, problem)
+			failures += 1
+			continue
+		}
+
+		fmt.Printf("ok   %q rejected\n", c.Statement)
+	}
+
+	// Statements name their tables relative to the working directory.
+	dir, err := ioutil.TempDir("", "pet-sql")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, format := range []table.Format{table.TextFormat, table.PageFormat} {
+		var sub string = filepath.Join(dir, format.String())
+		err = os.Mkdir(sub, 0777)
+		if err == nil {
+			err = os.Chdir(sub)
+		}
+		if err != nil {
+			fmt.Println("Setup failed:", err)
+			os.Exit(1)
+		}
+
+		failures += checkScript(format)
+	}
+
+	if failures > 0 {
+		fmt.Println(failures, "failures")
+		os.Exit(1)
+	}
+
+	fmt.Println("Every statement parsed and ran as expected.")
+}
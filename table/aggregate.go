@@ -0,0 +1,577 @@
+package table
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Aggregate queries summarise the rows which match a condition. In one pass
+ * over the table, the rows are gathered into groups by the columns of GROUP
+ * BY, or into a single group without it, and every group keeps only the
+ * running state of its aggregates:
+ *
+ *      COUNT(*), COUNT(column):    the number of rows, an integer
+ *      SUM(column):                an integer for an integer column, and
+ *                                  a double for a double column
+ *      AVG(column):                a double
+ *      MIN(column), MAX(column):   of the type of the column
+ *
+ * SUM, AVG, MIN and MAX only take integer and double columns. Each group
+ * then becomes a row of its GROUP BY columns followed by its aggregates, and
+ * HAVING and ORDER BY are checked, compiled and evaluated against these rows
+ * as if they were a table, so they can use everything a condition can. The
+ * single group of a query without GROUP BY exists even if no row matched,
+ * in which case its AVG, MIN and MAX are empty.
+ *
+ * Numbers and booleans are grouped by value, so `5` and `+5` fall in the
+ * same group, and strings byte for byte.
+**/
+
+var aggregate_functions []string = []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+var aggregate_pattern *regexp.Regexp = regexp.MustCompile(`^([A-Za-z]+)\((\*|[^()]+)\)$`)
+
+// IsAggregate reports whether a name is an aggregate such as `COUNT(*)` or
+// `avg(Salary)`, rather than a column.
+func IsAggregate(name string) bool {
+	var parts []string = aggregate_pattern.FindStringSubmatch(name)
+	return parts != nil && strings_contains(strings.ToUpper(parts[1]), aggregate_functions) != -1
+}
+
+// aggregateName returns the name of an aggregate as queries refer to it,
+// such as `AVG(Salary)`.
+func aggregateName(function string, argument string) string {
+	return strings.ToUpper(function) + "(" + argument + ")"
+}
+
+// foldAggregates joins the tokens of every aggregate into one bareword, with
+// the function in upper case: `avg ( Salary )` becomes `AVG(Salary)`.
+func foldAggregates(tokens []token) []token {
+	var result []token
+	for i := 0; i < len(tokens); i++ {
+		if i+3 < len(tokens) && tokens[i].Type == bareword_token_type && strings_contains(strings.ToUpper(tokens[i].Value), aggregate_functions) != -1 &&
+			tokens[i+1].Value == "(" && (tokens[i+2].Type == bareword_token_type || tokens[i+2].Value == "*") && tokens[i+3].Value == ")" {
+			var folded token = tokens[i]
+			folded.Value = aggregateName(tokens[i].Value, tokens[i+2].Value)
+			result = append(result, folded)
+			i += 3
+			continue
+		}
+
+		result = append(result, tokens[i])
+	}
+
+	return result
+}
+
+type aggregateCall struct {
+	Name     string
+	Function string
+	Column   int
+	Type     ColumnType
+}
+
+/**
+ * A queryGrouping describes the rows of the groups of a query: the columns
+ * of the table they are grouped by, followed by their aggregates, with the
+ * names and types HAVING and ORDER BY resolve against.
+**/
+type queryGrouping struct {
+	groups     []int
+	aggregates []aggregateCall
+	names      []string
+	types      []int
+	having     queryPlan
+}
+
+// isGrouped reports whether the clauses make a query an aggregate one.
+func isGrouped(clauses queryClauses) bool {
+	if len(clauses.Group) > 0 || clauses.Having != nil {
+		return true
+	}
+
+	for i := range clauses.Order {
+		if IsAggregate(clauses.Order[i].Token.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGrouping resolves GROUP BY, HAVING and ORDER BY of an aggregate
+// query, leaving the columns of ORDER BY pointing into the rows of groups.
+func checkGrouping(query string, clauses queryClauses, column_names []string, column_types []int, options QueryOptions) (*queryGrouping, error) {
+	var fail = func(offset int, reason string) (*queryGrouping, error) {
+		return nil, &QueryError{Query: query, Offset: offset, Reason: reason}
+	}
+
+	var result *queryGrouping = &queryGrouping{}
+	for i := range clauses.Group {
+		var ref *columnRef = &clauses.Group[i]
+		ref.Column = strings_contains(ref.Token.Value, column_names)
+		if ref.Column == -1 {
+			return fail(ref.Token.Offset, unknownColumn(ref.Token.Value))
+		} else if strings_contains(ref.Token.Value, result.names) != -1 {
+			return fail(ref.Token.Offset, "column "+ref.Token.Value+" is grouped by twice")
+		}
+
+		result.groups = append(result.groups, ref.Column)
+		result.names = append(result.names, column_names[ref.Column])
+		result.types = append(result.types, column_types[ref.Column])
+	}
+
+	if clauses.Having != nil {
+		var err error
+		walkTokens(clauses.Having, func(value token) {
+			if err == nil && value.Type == bareword_token_type {
+				_, err = result.resolve(query, value, column_names, column_types)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkQuery(query, clauses.Having, result.names, result.types)
+		if err != nil {
+			return nil, err
+		}
+
+		result.having, err = compileQuery(clauses.Having, result.types, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range clauses.Order {
+		var key *orderKey = &clauses.Order[i]
+
+		var err error
+		key.Column, err = result.resolve(query, key.Token, column_names, column_types)
+		if err != nil {
+			return nil, err
+		} else if key.Column == -1 {
+			return fail(key.Token.Offset, unknownColumn(key.Token.Value))
+		}
+	}
+
+	return result, nil
+}
+
+// walkTokens calls visit with the token of every value in a condition.
+func walkTokens(root *queryNode, visit func(value token)) {
+	if root == nil {
+		return
+	}
+
+	if root.Kind == literal_node_kind || root.Kind == column_node_kind {
+		visit(root.Token)
+	}
+
+	walkTokens(root.Left, visit)
+	walkTokens(root.Right, visit)
+	for i := range root.Values {
+		walkTokens(root.Values[i], visit)
+	}
+}
+
+// resolve returns the position of a grouped column or an aggregate in the
+// rows of groups, adding the aggregate if it is new. Names which are
+// neither, and not columns of the table either, return -1: in HAVING they
+// may still be values.
+func (g *queryGrouping) resolve(query string, name token, column_names []string, column_types []int) (int, error) {
+	if position := strings_contains(name.Value, g.names); position != -1 {
+		return position, nil
+	}
+
+	if !IsAggregate(name.Value) {
+		if column := strings_contains(name.Value, column_names); column != -1 {
+			return -1, &QueryError{Query: query, Offset: name.Offset, Reason: "column " + name.Value + " must be in GROUP BY or inside an aggregate, such as MIN(" + name.Value + ")"}
+		}
+		return -1, nil
+	}
+
+	call, err := parseAggregate(name.Value, column_names, column_types)
+	if err != nil {
+		return -1, &QueryError{Query: query, Offset: name.Offset, Reason: err.Error()}
+	}
+
+	g.aggregates = append(g.aggregates, call)
+	g.names = append(g.names, call.Name)
+	g.types = append(g.types, int(call.Type))
+	return len(g.names) - 1, nil
+}
+
+// parseAggregate checks an aggregate such as `AVG(Salary)` against the
+// columns of a table.
+func parseAggregate(name string, column_names []string, column_types []int) (aggregateCall, error) {
+	var parts []string = aggregate_pattern.FindStringSubmatch(name)
+	var result aggregateCall = aggregateCall{Name: aggregateName(parts[1], parts[2]), Function: strings.ToUpper(parts[1]), Column: -1}
+
+	if parts[2] == "*" {
+		if result.Function != "COUNT" {
+			return result, errors.New(result.Function + " needs a column, not *")
+		}
+		result.Type = Integer
+		return result, nil
+	}
+
+	result.Column = strings_contains(parts[2], column_names)
+	if result.Column == -1 {
+		return result, errors.New("unknown column `" + parts[2] + "` in " + result.Name)
+	}
+
+	var column_type ColumnType = ColumnType(column_types[result.Column])
+	if result.Function == "COUNT" {
+		result.Type = Integer
+		return result, nil
+	} else if column_type != Integer && column_type != Double {
+		return result, errors.New(result.Function + " needs an integer or double column, but " + parts[2] + " is of type " + column_type.String())
+	}
+
+	result.Type = column_type
+	if result.Function == "AVG" {
+		result.Type = Double
+	}
+	return result, nil
+}
+
+// An aggregateState is the running state of one aggregate of a group.
+type aggregateState struct {
+	count int
+	value queryNumber
+	sum   float64
+}
+
+type group struct {
+	values []string
+	rows   int
+	states []aggregateState
+}
+
+// Groups holds the results of Aggregate, one row per group.
+type Groups struct {
+	columns []Column
+	rows    [][]string
+	current []string
+	indexes []string
+}
+
+// Columns returns the names and types of the values of every group.
+func (g *Groups) Columns() []Column {
+	return g.columns
+}
+
+func (g *Groups) Next() bool {
+	if len(g.rows) == 0 {
+		return false
+	}
+
+	g.current = g.rows[0]
+	g.rows = g.rows[1:]
+	return true
+}
+
+// Len returns the number of groups not yet read by Next.
+func (g *Groups) Len() int {
+	return len(g.rows)
+}
+
+func (g *Groups) Values() []string {
+	return g.current
+}
+
+// Indexes returns the columns whose indexes were used to find the rows.
+func (g *Groups) Indexes() []string {
+	return g.indexes
+}
+
+/**
+ * Aggregate reads the rows matching a query in one pass and returns one row
+ * per group, holding the values of selected: the columns of GROUP BY and
+ * aggregates such as COUNT(*) or AVG(Salary). With nothing selected, the
+ * rows hold the columns of GROUP BY, COUNT(*), and the aggregates used by
+ * HAVING and ORDER BY. Groups come in the order of their first row unless
+ * the query orders them; OFFSET and LIMIT count groups.
+**/
+func (t *Table) Aggregate(query *Query, selected []string) (*Groups, error) {
+	var grouping queryGrouping
+	if query.grouping != nil {
+		grouping = *query.grouping
+	} else if len(query.clauses.Order) > 0 {
+		return nil, &SchemaError{Reason: "a query without GROUP BY can only order the rows of a search, not aggregates"}
+	}
+
+	// Aggregates only selected are added after those of the query, which
+	// were compiled into HAVING and ORDER BY, so that those keep their
+	// positions.
+	grouping.aggregates = append([]aggregateCall(nil), grouping.aggregates...)
+	grouping.names = append([]string(nil), grouping.names...)
+	grouping.types = append([]int(nil), grouping.types...)
+
+	if len(selected) == 0 {
+		selected = append(selected, grouping.names[:len(grouping.groups)]...)
+		selected = append(selected, "COUNT(*)")
+		for i := range grouping.aggregates {
+			if grouping.aggregates[i].Name != "COUNT(*)" {
+				selected = append(selected, grouping.aggregates[i].Name)
+			}
+		}
+	}
+
+	var result *Groups = &Groups{}
+	var positions []int
+	for i := range selected {
+		var name token = token{Value: selected[i], Type: bareword_token_type}
+		if IsAggregate(name.Value) {
+			var parts []string = aggregate_pattern.FindStringSubmatch(name.Value)
+			name.Value = aggregateName(parts[1], parts[2])
+		}
+
+		position, err := grouping.resolve(selected[i], name, query.column_names, query.column_types)
+		if err != nil {
+			return nil, &SchemaError{Reason: err.(*QueryError).Reason}
+		} else if position == -1 {
+			return nil, &SchemaError{Reason: "unknown attribute `" + selected[i] + "`"}
+		}
+
+		positions = append(positions, position)
+		if position < len(grouping.groups) {
+			result.columns = append(result.columns, t.columns[grouping.groups[position]])
+		} else {
+			result.columns = append(result.columns, Column{Name: grouping.names[position], Type: ColumnType(grouping.types[position])})
+		}
+	}
+
+	groups, indexes, err := t.gather(query, &grouping)
+	if err != nil {
+		return nil, err
+	}
+	result.indexes = indexes
+
+	var rows []Row
+	for i := range groups {
+		var values []string = groups[i].finish(&grouping)
+		if grouping.having != nil {
+			matched, err := grouping.having(values)
+			if err != nil && groups[i].rows == 0 {
+				// The empty AVG, MIN and MAX of a table with no matching
+				// rows satisfy no condition.
+				continue
+			} else if err != nil {
+				return nil, errors.New("Unable to evaluate HAVING for the group of " + strings.Join(groups[i].values, ", ") + ": " + err.Error())
+			} else if !matched {
+				continue
+			}
+		}
+
+		rows = append(rows, Row{RID: i, Values: values})
+	}
+
+	rows, err = orderGroups(rows, query, &grouping)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		var values []string
+		for _, position := range positions {
+			values = append(values, rows[i].Values[position])
+		}
+		result.rows = append(result.rows, values)
+	}
+
+	return result, nil
+}
+
+// gather reads every row matching the query into its group.
+func (t *Table) gather(query *Query, grouping *queryGrouping) ([]*group, []string, error) {
+	rows, err := t.scan(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var result []*group
+	var keys map[string]*group = map[string]*group{}
+	// The clauses of the query apply to the groups, not to the rows.
+	for rows.nextMatch() {
+		var row Row = rows.Row()
+
+		var values []string
+		for _, column := range grouping.groups {
+			value, err := groupValue(query.column_types[column], row.Values[column])
+			if err != nil {
+				return nil, nil, &RowError{RID: row.RID, Reason: err.Error()}
+			}
+			values = append(values, value)
+		}
+
+		var key string = formatRecord(0, values)
+		var current *group = keys[key]
+		if current == nil {
+			current = &group{values: values, states: make([]aggregateState, len(grouping.aggregates))}
+			keys[key] = current
+			result = append(result, current)
+		}
+
+		err = current.add(grouping, row.Values)
+		if err != nil {
+			return nil, nil, &RowError{RID: row.RID, Reason: err.Error()}
+		}
+	}
+
+	if rows.Err() != nil {
+		return nil, nil, rows.Err()
+	}
+
+	// Without GROUP BY there is always exactly one group.
+	if len(grouping.groups) == 0 && len(result) == 0 {
+		result = append(result, &group{states: make([]aggregateState, len(grouping.aggregates))})
+	}
+
+	return result, rows.Indexes(), nil
+}
+
+// groupValue returns a value in the form it is grouped and shown by.
+func groupValue(column_type int, value string) (string, error) {
+	switch column_type {
+	case 1:
+		result, err := parseRowInteger(value)
+		return strconv.Itoa(result), err
+	case 2:
+		result, err := parseRowDouble(value)
+		return formatDouble(result), err
+	case 3:
+		return parseRowBoolean(value)
+	case 5, 6:
+		// Both are stored in canonical form.
+		_, err := parseRowExact(value)
+		return value, err
+	}
+
+	return value, nil
+}
+
+// add updates the aggregates of a group with a row.
+func (g *group) add(grouping *queryGrouping, values []string) error {
+	g.rows += 1
+	for i := range grouping.aggregates {
+		var call *aggregateCall = &grouping.aggregates[i]
+		var state *aggregateState = &g.states[i]
+
+		state.count += 1
+		if call.Function == "COUNT" {
+			continue
+		}
+
+		var value queryNumber
+		var err error
+		if call.Type == Double {
+			value.double = true
+			value.real, err = parseRowDouble(values[call.Column])
+		} else {
+			value.integer, err = parseRowInteger(values[call.Column])
+		}
+		if err != nil {
+			return err
+		}
+
+		switch call.Function {
+		case "SUM":
+			if state.count == 1 {
+				state.value = value
+			} else {
+				state.value, err = applyArithmetic("+", state.value, value, call.Name)
+			}
+		case "AVG":
+			state.sum += value.real
+		case "MIN":
+			if state.count == 1 || compareNumbers(value, state.value) < 0 {
+				state.value = value
+			}
+		case "MAX":
+			if state.count == 1 || compareNumbers(value, state.value) > 0 {
+				state.value = value
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finish returns the row of a group: its grouped values and aggregates.
+func (g *group) finish(grouping *queryGrouping) []string {
+	var result []string = append([]string(nil), g.values...)
+	for i := range grouping.aggregates {
+		var state aggregateState = g.states[i]
+
+		var value string
+		if grouping.aggregates[i].Function == "COUNT" {
+			value = strconv.Itoa(state.count)
+		} else if grouping.aggregates[i].Function == "AVG" {
+			if state.count > 0 {
+				value = formatDouble(state.sum / float64(state.count))
+			}
+		} else if state.count > 0 || grouping.aggregates[i].Function == "SUM" {
+			value = strconv.Itoa(state.value.integer)
+			if state.value.double || grouping.aggregates[i].Type == Double {
+				value = formatDouble(state.value.asDouble())
+			}
+		}
+
+		result = append(result, value)
+	}
+
+	return result
+}
+
+// orderGroups sorts the rows of groups by ORDER BY and applies OFFSET and
+// LIMIT.
+func orderGroups(rows []Row, query *Query, grouping *queryGrouping) ([]Row, error) {
+	var clauses queryClauses = query.clauses
+
+	// A single group needs no sorting, and may hold empty aggregates.
+	if len(clauses.Order) > 0 && len(rows) > 1 {
+		var sorter *rowSorter = newRowSorter(clauses, grouping.types, query.options.Collation)
+		defer sorter.close()
+
+		for i := range rows {
+			err := sorter.add(rows[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := sorter.finish()
+		if err != nil {
+			return nil, err
+		}
+
+		rows = nil
+		for {
+			row, ok, err := sorter.next()
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	if clauses.Offset >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[clauses.Offset:]
+
+	if clauses.Limit != -1 && clauses.Limit < len(rows) {
+		rows = rows[:clauses.Limit]
+	}
+	return rows, nil
+}
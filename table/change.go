@@ -0,0 +1,350 @@
+package table
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"strconv"
+)
+
+/**
+ * Statements change several rows at once (see InsertRows, UpdateWhere and
+ * DeleteWhere). Each finds its rows and changes them under one exclusive
+ * lock, so no other writer can change them in between, and as a single
+ * journaled mutation, so a crash leaves either every change or none:
+ *
+ *      current text table:     op changes, appending every new record and
+ *                              then tombstoning every old one
+ *      older text table:       op rewrite, with every record of the new
+ *                              table, which is then of the current version
+ *      page table:             op pages, with every page it touches
+ *
+ * Updated rows keep their row ids, as with Update, and the indexes take
+ * every change under a single flush.
+**/
+
+// matchingRows reads every row matching the query. The caller must hold a
+// lock on the table.
+func (t *Table) matchingRows(query *Query) ([]Row, error) {
+	if query.Grouped() {
+		return nil, &SchemaError{Reason: "query groups its rows; it cannot pick the rows to change"}
+	}
+
+	rows, err := t.read(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Row
+	for rows.Next() {
+		result = append(result, rows.Row())
+	}
+
+	return result, rows.Err()
+}
+
+// changeRows applies a statement to the table: it inserts the new rows,
+// replaces the values of the updated rows and removes the deleted ones. All
+// values must already be checked with ParseValue. The caller must hold an
+// exclusive lock on the table.
+func (t *Table) changeRows(inserted [][]string, updated []Row, deleted []int) error {
+	if len(inserted) == 0 && len(updated) == 0 && len(deleted) == 0 {
+		return nil
+	}
+
+	var before header = t.header
+	var changes []indexChange
+	var err error
+
+	if t.format == PageFormat {
+		changes, err = t.changePages(inserted, updated, deleted)
+	} else if t.version == currentVersion {
+		changes, err = t.changeText(inserted, updated, deleted)
+	} else {
+		changes, err = t.rewriteText(inserted, updated, deleted)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return t.changeIndexes(before, changes)
+}
+
+// changeText applies a statement to a current text table in place.
+func (t *Table) changeText(inserted [][]string, updated []Row, deleted []int) ([]indexChange, error) {
+	var row_ids map[int]bool = map[int]bool{}
+	for i := range updated {
+		row_ids[updated[i].RID] = true
+	}
+	for i := range deleted {
+		row_ids[deleted[i]] = true
+	}
+
+	found, err := findRecords(t.filename, t.header, row_ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var after header = t.header
+	var result *journal = &journal{op: "changes"}
+	var changes []indexChange
+
+	// remove tombstones the old record of a row and returns its values.
+	var remove = func(row_id int) error {
+		old, ok := found[row_id]
+		if !ok {
+			return &NotFoundError{RID: row_id}
+		}
+
+		_, _, values, err := parseRecord(old.line, len(t.columns), t.version)
+		if err != nil {
+			return err
+		}
+
+		result.tombstones = append(result.tombstones, old)
+		changes = append(changes, indexChange{row_id: row_id, values: values, location: old.offset})
+		return nil
+	}
+
+	// add appends a record after those added so far.
+	var add = func(row_id int, values []string) {
+		var line string = formatRecord(row_id, values)
+		result.records = append(result.records, line)
+		changes = append(changes, indexChange{row_id: row_id, values: values, location: after.size, inserted: true})
+		after.size += int64(len(line) + 1)
+	}
+
+	for i := range updated {
+		err = remove(updated[i].RID)
+		if err != nil {
+			return nil, err
+		}
+		add(updated[i].RID, updated[i].Values)
+	}
+
+	for i := range deleted {
+		err = remove(deleted[i])
+		if err != nil {
+			return nil, err
+		}
+		after.records -= 1
+	}
+
+	for i := range inserted {
+		add(after.next_rid, inserted[i])
+		after.records += 1
+		after.next_rid += 1
+	}
+
+	result.before = formatHeader(t.header)
+	result.after = formatHeader(after)
+	return changes, t.commit(result)
+}
+
+// rewriteText applies a statement to a text table older than the current
+// version by rewriting it, in which the row id of every record is its
+// position. The rewrite moves every record, so the indexes are rebuilt.
+func (t *Table) rewriteText(inserted [][]string, updated []Row, deleted []int) ([]indexChange, error) {
+	before_line, err := readHeaderLine(t.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := readLines(t.filename, t.header)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range updated {
+		var row_id int = updated[i].RID
+		if row_id < 0 || row_id >= len(lines) {
+			return nil, &NotFoundError{RID: row_id}
+		}
+
+		lines[row_id] = formatRecord(row_id, updated[i].Values)
+	}
+
+	var removed map[int]bool = map[int]bool{}
+	for i := range deleted {
+		if deleted[i] < 0 || deleted[i] >= len(lines) {
+			return nil, &NotFoundError{RID: deleted[i]}
+		}
+
+		removed[deleted[i]] = true
+	}
+
+	var next_rid int = len(lines)
+	var records []string
+	for i := range lines {
+		if !removed[i] {
+			records = append(records, lines[i])
+		}
+	}
+
+	for i := range inserted {
+		records = append(records, formatRecord(next_rid, inserted[i]))
+		next_rid += 1
+	}
+
+	var after header = rewriteHeader(t.header, records)
+	after.next_rid = next_rid
+
+	err = t.commit(&journal{op: "rewrite", before: before_line, after: formatHeader(after), records: records})
+	return []indexChange{{location: -1}}, err
+}
+
+// changePages applies a statement to a page format table. Old records are
+// deleted in their pages; an updated row is placed back in its page when it
+// fits there and otherwise, like a new row, in the last page or a new one.
+func (t *Table) changePages(inserted [][]string, updated []Row, deleted []int) ([]indexChange, error) {
+	f, err := os.Open(t.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var row_ids map[int]bool = map[int]bool{}
+	for i := range updated {
+		row_ids[updated[i].RID] = true
+	}
+	for i := range deleted {
+		row_ids[deleted[i]] = true
+	}
+
+	// The images of the pages changed so far, by page number.
+	var images map[int][]byte = map[int][]byte{}
+	var old_numbers map[int]int = map[int]int{}
+	var old_changes map[int]indexChange = map[int]indexChange{}
+
+	for number := 1; number < t.pages && len(old_changes) < len(row_ids); number++ {
+		page, err := readPage(f, number)
+		if err != nil {
+			return nil, err
+		}
+
+		for slot := 0; slot < pageSlots(page); slot++ {
+			record, err := pageRecord(page, slot)
+			if err == nil && (record[0] != 1 || !row_ids[int(binary.BigEndian.Uint64(record[1:]))]) {
+				continue
+			}
+
+			var row_id int
+			var values []string
+			if err == nil {
+				row_id, _, values, err = decodeRecord(t.columns, record)
+			}
+
+			if err != nil {
+				return nil, &FormatError{Filename: t.filename, Line: 0, Reason: "page " + strconv.Itoa(number) + ", slot " + strconv.Itoa(slot) + ": " + err.Error()}
+			}
+
+			// record aliases page, so this clears the status in the image.
+			record[0] = 0
+			images[number] = page
+			old_numbers[row_id] = number
+			old_changes[row_id] = indexChange{row_id: row_id, values: values, location: slotLocation(number, slot)}
+		}
+	}
+
+	var after header = t.header
+	after.changes += len(inserted) + len(updated) + len(deleted)
+
+	// place adds a record to the page numbered first, if there is one and
+	// the record fits, and otherwise to the last page or a new one. It
+	// returns the location of the record.
+	var place = func(record []byte, first int) (int64, error) {
+		for _, number := range []int{first, after.pages - 1} {
+			if number < 1 {
+				continue
+			}
+
+			page, ok := images[number]
+			if !ok {
+				page, err = readPage(f, number)
+				if err != nil {
+					return -1, err
+				}
+			}
+
+			if addRecord(page, record) {
+				images[number] = page
+				return slotLocation(number, pageSlots(page)-1), nil
+			}
+		}
+
+		var number int = after.pages
+		images[number] = newDataPage()
+		addRecord(images[number], record)
+		after.pages += 1
+		after.size += int64(pageSize)
+		return slotLocation(number, 0), nil
+	}
+
+	var changes []indexChange
+	for i := range updated {
+		var row_id int = updated[i].RID
+		old, ok := old_changes[row_id]
+		if !ok {
+			return nil, &NotFoundError{RID: row_id}
+		}
+
+		record, err := encodeRecord(t.columns, row_id, updated[i].Values)
+		if err != nil {
+			return nil, err
+		}
+
+		location, err := place(record, old_numbers[row_id])
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, old, indexChange{row_id: row_id, values: updated[i].Values, location: location, inserted: true})
+	}
+
+	for i := range deleted {
+		old, ok := old_changes[deleted[i]]
+		if !ok {
+			return nil, &NotFoundError{RID: deleted[i]}
+		}
+
+		changes = append(changes, old)
+		after.records -= 1
+	}
+
+	for i := range inserted {
+		record, err := encodeRecord(t.columns, after.next_rid, inserted[i])
+		if err != nil {
+			return nil, err
+		}
+
+		location, err := place(record, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, indexChange{row_id: after.next_rid, values: inserted[i], location: location, inserted: true})
+		after.records += 1
+		after.next_rid += 1
+	}
+
+	var numbers []int
+	for number := range images {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	var pages []pageImage
+	for _, number := range numbers {
+		pages = append(pages, pageImage{number: number, data: images[number]})
+	}
+
+	header_page, err := encodePageHeader(after)
+	if err != nil {
+		return nil, err
+	}
+	pages = append(pages, pageImage{number: 0, data: header_page})
+
+	return changes, t.commit(&journal{op: "pages", pages: pages})
+}
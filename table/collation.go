@@ -0,0 +1,156 @@
+package table
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/**
+ * A Collation decides how search orders strings for <, <=, > and >=.
+ *
+ *      binary:     byte by byte, so that 'Z' < 'a' and 'é' sorts after 'z'.
+ *      nocase:     as binary, but comparing the lower case of every letter,
+ *                  so that 'm' and 'M' are equal.
+ *      unicode:    letters first compare by their base letter ignoring case
+ *                  and accents, then by accents, then lower case before
+ *                  upper case, and finally byte by byte. Accents are only
+ *                  known for the Latin letters in accentedLetters.
+**/
+type Collation int
+
+const (
+	BinaryCollation Collation = iota
+	NoCaseCollation
+	UnicodeCollation
+)
+
+var collationNames map[Collation]string = map[Collation]string{BinaryCollation: "binary", NoCaseCollation: "nocase", UnicodeCollation: "unicode"}
+
+func (c Collation) String() string {
+	name, ok := collationNames[c]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+// ParseCollation returns the collation with the given name.
+func ParseCollation(name string) (Collation, error) {
+	for collation := range collationNames {
+		if collationNames[collation] == name {
+			return collation, nil
+		}
+	}
+
+	return BinaryCollation, errors.New("unknown collation `" + name + "`: expected binary, nocase or unicode")
+}
+
+// Compare returns -1, 0 or 1 as a sorts before, with or after b.
+func (c Collation) Compare(a string, b string) int {
+	if c == NoCaseCollation {
+		return compareRunes(a, b, unicode.ToLower)
+	} else if c == UnicodeCollation {
+		for _, level := range []func(rune) rune{baseLetter, unicode.ToLower, caseLevel} {
+			var comparison int = compareRunes(a, b, level)
+			if comparison != 0 {
+				return comparison
+			}
+		}
+	}
+
+	return strings.Compare(a, b)
+}
+
+// compareRunes compares two strings rune by rune after mapping every rune.
+func compareRunes(a string, b string, mapping func(rune) rune) int {
+	for len(a) > 0 && len(b) > 0 {
+		left, left_size := utf8.DecodeRuneInString(a)
+		right, right_size := utf8.DecodeRuneInString(b)
+
+		left, right = mapping(left), mapping(right)
+		if left < right {
+			return -1
+		} else if left > right {
+			return 1
+		}
+
+		a, b = a[left_size:], b[right_size:]
+	}
+
+	if len(a) > 0 {
+		return 1
+	} else if len(b) > 0 {
+		return -1
+	}
+	return 0
+}
+
+/**
+ * accentedLetters lists Latin letters with diacritics, grouped by the base
+ * letter they sort with under the unicode collation.
+**/
+var accentedLetters map[rune]string = map[rune]string{
+	'a': "àáâãäåāăą",
+	'c': "çćĉċč",
+	'd': "ďđ",
+	'e': "èéêëēĕėęě",
+	'g': "ĝğġģ",
+	'h': "ĥħ",
+	'i': "ìíîïĩīĭįı",
+	'j': "ĵ",
+	'k': "ķ",
+	'l': "ĺļľŀł",
+	'n': "ñńņňŉ",
+	'o': "òóôõöøōŏő",
+	'r': "ŕŗř",
+	's': "śŝşš",
+	't': "ţťŧ",
+	'u': "ùúûüũūŭůűų",
+	'w': "ŵ",
+	'y': "ýÿŷ",
+	'z': "źżž",
+}
+
+var baseLetters map[rune]rune = map[rune]rune{}
+
+func init() {
+	for base, letters := range accentedLetters {
+		for _, letter := range letters {
+			baseLetters[letter] = base
+		}
+	}
+}
+
+func baseLetter(r rune) rune {
+	r = unicode.ToLower(r)
+	if base, ok := baseLetters[r]; ok {
+		return base
+	}
+	return r
+}
+
+// caseLevel sorts lower case letters before upper case ones.
+func caseLevel(r rune) rune {
+	if unicode.IsUpper(r) {
+		return 1
+	}
+	return 0
+}
+
+// foldCase maps every rune to the smallest rune it case folds to, so that
+// two strings are equal under strings.EqualFold exactly when they fold to
+// the same string.
+func foldCase(value string) string {
+	return strings.Map(func(r rune) rune {
+		var smallest rune = r
+		for folded := unicode.SimpleFold(r); folded != r; folded = unicode.SimpleFold(folded) {
+			if folded < smallest {
+				smallest = folded
+			}
+		}
+		return smallest
+	}, value)
+}
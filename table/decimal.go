@@ -0,0 +1,132 @@
+package table
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Big integers and decimals are stored as text in both formats, in the
+ * canonical form ParseValue gives them: a bigint has no plus sign or leading
+ * zeros, and a decimal(p,s) has exactly s digits after the point, so that
+ * 1.5 in a decimal(5,2) column is stored as `1.50`. A decimal holds at most
+ * p-s digits before the point and is never rounded.
+ *
+ * Search compares these columns exactly, as rationals: so are the integers,
+ * doubles and number literals compared with them, each taken as written.
+**/
+
+const max_decimal_precision int = 1000
+
+// A literal with a longer exponent than this is refused rather than
+// expanded into millions of digits.
+const max_exponent_digits int = 4
+
+var bigint_pattern *regexp.Regexp = regexp.MustCompile(`^[+-]?[0-9]+$`)
+var decimal_pattern *regexp.Regexp = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+
+// parseBigIntegerValue validates a value for a bigint column and returns it
+// in canonical form.
+func parseBigIntegerValue(column Column, value string) (string, error) {
+	if !bigint_pattern.MatchString(value) {
+		return "", &ValueError{Column: column, Value: value, Reason: "unable to convert input to bigint: expected digits with an optional sign"}
+	}
+
+	var result *big.Int = new(big.Int)
+	result.SetString(value, 10)
+	return result.String(), nil
+}
+
+// parseDecimalValue validates a value for a decimal column and returns it in
+// canonical form.
+func parseDecimalValue(column Column, value string) (string, error) {
+	if !decimal_pattern.MatchString(value) {
+		return "", &ValueError{Column: column, Value: value, Reason: "unable to convert input to decimal: expected digits with an optional sign and point"}
+	}
+
+	var result *big.Rat = new(big.Rat)
+	result.SetString(value)
+
+	var scaled *big.Int = new(big.Int).Mul(result.Num(), pow10(column.Scale))
+	var remainder *big.Int = new(big.Int)
+	scaled.QuoRem(scaled, result.Denom(), remainder)
+	if remainder.Sign() != 0 {
+		return "", &ValueError{Column: column, Value: value, Reason: "more than " + strconv.Itoa(column.Scale) + " digits after the point"}
+	}
+
+	var digits string = new(big.Int).Abs(scaled).String()
+	if len(digits) > column.Precision {
+		return "", &ValueError{Column: column, Value: value, Reason: "more than " + strconv.Itoa(column.Precision-column.Scale) + " digits before the point"}
+	}
+
+	for len(digits) <= column.Scale {
+		digits = "0" + digits
+	}
+
+	var formatted string = digits[:len(digits)-column.Scale]
+	if column.Scale > 0 {
+		formatted += "." + digits[len(digits)-column.Scale:]
+	}
+
+	if scaled.Sign() < 0 {
+		formatted = "-" + formatted
+	}
+	return formatted, nil
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+// parseBigInteger parses an integer literal, of any size, for a bigint
+// column.
+func parseBigInteger(text string) (*big.Rat, error) {
+	if !isIntegerLiteral(text) {
+		return nil, errors.New("cannot convert `" + text + "` to bigint")
+	}
+	return parseExact(text)
+}
+
+// parseExact parses any number literal exactly.
+func parseExact(text string) (*big.Rat, error) {
+	var result *big.Rat = new(big.Rat)
+
+	if isIntegerLiteral(text) && strings.ContainsAny(text, "xX") {
+		var value *big.Int = new(big.Int)
+		if _, ok := value.SetString(text, 0); !ok {
+			return nil, errors.New("cannot convert `" + text + "` to a number")
+		}
+		return result.SetInt(value), nil
+	}
+
+	if !exponentInRange(text) {
+		return nil, errors.New("exponent of `" + text + "` is out of range")
+	}
+
+	if _, ok := result.SetString(text); !ok {
+		return nil, errors.New("cannot convert `" + text + "` to a number")
+	}
+	return result, nil
+}
+
+func exponentInRange(text string) bool {
+	var exponent int = strings.IndexAny(text, "eE")
+	return exponent == -1 || len(strings.TrimLeft(text[exponent+1:], "+-0")) <= max_exponent_digits
+}
+
+// parseRowExact reads an integer, double, bigint or decimal value of a row
+// exactly as it is written.
+func parseRowExact(value string) (*big.Rat, error) {
+	var result *big.Rat = new(big.Rat)
+	if strings.Contains(value, "/") || !exponentInRange(value) {
+		return nil, errors.New("Unable to convert row value to number: " + value)
+	}
+
+	if _, ok := result.SetString(value); !ok {
+		return nil, errors.New("Unable to convert row value to number: " + value)
+	}
+	return result, nil
+}
@@ -0,0 +1,158 @@
+package table
+
+import (
+	"bufio"
+	"os"
+)
+
+func (t *Table) Delete(row_id int) error {
+	l, err := t.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+
+	var before header = t.header
+
+	if t.format == PageFormat {
+		location, values, err := t.deletePages(row_id)
+		if err != nil {
+			return err
+		}
+
+		return t.updateIndexes(before, row_id, values, location, false)
+	}
+
+	if t.version == currentVersion {
+		offset, line, err := findRecord(t.filename, t.header, row_id)
+		if err != nil {
+			return err
+		}
+
+		_, _, values, err := parseRecord(line, len(t.columns), t.version)
+		if err != nil {
+			return err
+		}
+
+		var after header = t.header
+		after.records -= 1
+
+		err = t.commit(&journal{op: "tombstone", before: formatHeader(t.header), after: formatHeader(after), rid: row_id, offset: offset, row: line})
+		if err != nil {
+			return err
+		}
+
+		return t.updateIndexes(before, row_id, values, offset, false)
+	}
+
+	// Older formats are upgraded with a full rewrite, in which the row id
+	// of every record is its position.
+	before_line, err := readHeaderLine(t.filename)
+	if err != nil {
+		return err
+	}
+
+	lines, err := readLines(t.filename, t.header)
+	if err != nil {
+		return err
+	}
+
+	if row_id < 0 || row_id >= len(lines) {
+		return &NotFoundError{RID: row_id}
+	}
+
+	var next_rid int = len(lines)
+	var row string = lines[row_id]
+	var saved []string = lines[row_id+1:]
+	lines = append(lines[0:row_id], saved...)
+
+	var after header = rewriteHeader(t.header, lines)
+	after.next_rid = next_rid
+
+	err = t.commit(&journal{op: "delete", before: before_line, after: formatHeader(after), rid: row_id, row: row})
+	if err != nil {
+		return err
+	}
+
+	// The rewrite moved every record, so the indexes are rebuilt.
+	return t.updateIndexes(before, row_id, nil, -1, false)
+}
+
+// DeleteWhere deletes every row matching the query and returns how many it
+// deleted. The rows are found and deleted under one exclusive lock, in a
+// single journaled mutation (see change.go).
+func (t *Table) DeleteWhere(query *Query) (int, error) {
+	l, err := t.acquire(true)
+	if err != nil {
+		return 0, err
+	}
+	defer l.release()
+
+	rows, err := t.matchingRows(query)
+	if err != nil {
+		return 0, err
+	}
+
+	var row_ids []int
+	for i := range rows {
+		row_ids = append(row_ids, rows[i].RID)
+	}
+
+	err = t.changeRows(nil, nil, row_ids)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rows), nil
+}
+
+// findRecord returns the byte offset and line of the live record with the
+// given row id in a current table.
+func findRecord(filename string, h header, row_id int) (int64, string, error) {
+	found, err := findRecords(filename, h, map[int]bool{row_id: true})
+	if err != nil {
+		return 0, "", err
+	}
+
+	record, ok := found[row_id]
+	if !ok {
+		return 0, "", &NotFoundError{RID: row_id}
+	}
+
+	return record.offset, record.line, nil
+}
+
+// findRecords returns the byte offset and line of each live record with one
+// of the given row ids in a current table, by row id.
+func findRecords(filename string, h header, row_ids map[int]bool) (map[int]tombstone, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(committedReader(f, h))
+	s.Scan()
+
+	var result map[int]tombstone = map[int]tombstone{}
+	var offset int64 = int64(len(s.Text()) + 1)
+	var line_number int = 0
+
+	for len(result) < len(row_ids) && s.Scan() {
+		var line string = s.Text()
+		line_number += 1
+
+		id, live, _, err := parseRecord(line, len(h.columns), h.version)
+		if err != nil {
+			return nil, &FormatError{Filename: filename, Line: line_number, Reason: err.Error()}
+		}
+
+		if live && row_ids[id] {
+			result[id] = tombstone{offset: offset, line: line}
+		}
+
+		offset += int64(len(line) + 1)
+	}
+
+	return result, s.Err()
+}
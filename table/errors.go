@@ -0,0 +1,142 @@
+package table
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrNotExist = errors.New("table does not exist")
+var ErrExist = errors.New("table already exists; refusing to overwrite")
+
+// FormatError reports a table file that cannot be parsed.
+type FormatError struct {
+	Filename string
+	Line     int
+	Reason   string
+}
+
+func (e *FormatError) Error() string {
+	return "malformed file `" + e.Filename + "` at line " + strconv.Itoa(e.Line) + ": " + e.Reason
+}
+
+// CountError is the recoverable mismatch between the record count stored in
+// the header and the number of records actually present in the file.
+type CountError struct {
+	Header int
+	Actual int
+}
+
+func (e *CountError) Error() string {
+	return "number of records do not match header record count. Using number in file: " + strconv.Itoa(e.Actual) + " vs " + strconv.Itoa(e.Header)
+}
+
+// UncommittedError is the recoverable presence of data past the size recorded
+// in the header, left behind by an insert that did not complete. It is
+// ignored by readers and overwritten by the next insert.
+type UncommittedError struct {
+	Bytes int64
+}
+
+func (e *UncommittedError) Error() string {
+	return "ignoring " + strconv.FormatInt(e.Bytes, 10) + " bytes of uncommitted data after the last record"
+}
+
+// LockError reports a table lock which could not be obtained in time.
+type LockError struct {
+	Filename  string
+	Exclusive bool
+	Timeout   time.Duration
+}
+
+func (e *LockError) Error() string {
+	var kind string = "shared"
+	if e.Exclusive {
+		kind = "exclusive"
+	}
+
+	return "timed out after " + e.Timeout.String() + " waiting for " + kind + " lock on `" + e.Filename + "`; is another pet process using it?"
+}
+
+// JournalError reports a journal which cannot be applied to its table. The
+// journal is left in place for manual inspection.
+type JournalError struct {
+	Filename string
+	Reason   string
+}
+
+func (e *JournalError) Error() string {
+	return "cannot recover from journal `" + e.Filename + "`: " + e.Reason
+}
+
+// NotFoundError reports a row id which does not name a live record.
+type NotFoundError struct {
+	RID int
+}
+
+func (e *NotFoundError) Error() string {
+	return "no record with row id " + strconv.Itoa(e.RID)
+}
+
+// IndexError reports an index which could not be built, updated or read.
+type IndexError struct {
+	Column string
+	Reason string
+}
+
+func (e *IndexError) Error() string {
+	return "index on " + e.Column + ": " + e.Reason
+}
+
+// SchemaError reports an invalid set of columns or values for a table.
+type SchemaError struct {
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return e.Reason
+}
+
+// ValueError reports a value which cannot be stored in the given column.
+type ValueError struct {
+	Column Column
+	Value  string
+	Reason string
+}
+
+func (e *ValueError) Error() string {
+	return "invalid value `" + e.Value + "` for " + e.Column.Name + " (" + e.Column.TypeName() + "): " + e.Reason
+}
+
+// QueryError reports a search query which could not be parsed or validated.
+// Offset is the byte offset in Query of the problem, which Error marks with a
+// caret underneath the query.
+type QueryError struct {
+	Query  string
+	Offset int
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	var marker []byte
+	for i := 0; i < e.Offset && i < len(e.Query); i++ {
+		// Keep tabs so that the caret lines up however they are shown.
+		if e.Query[i] == '\t' {
+			marker = append(marker, '\t')
+		} else if e.Query[i] < 0x80 || e.Query[i] >= 0xc0 {
+			marker = append(marker, ' ')
+		}
+	}
+
+	return e.Reason + " at position " + strconv.Itoa(e.Offset+1) + ":\n    " + e.Query + "\n    " + string(marker) + "^"
+}
+
+// RowError reports a stored row which could not be read or evaluated.
+type RowError struct {
+	RID    int
+	Reason string
+}
+
+func (e *RowError) Error() string {
+	return "row " + strconv.Itoa(e.RID) + ": " + e.Reason
+}
@@ -0,0 +1,324 @@
+package table
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/**
+ * On-disk text format:
+ *
+ *      [v<version>][<columns>][<name>:<type>]...[<records>][<size>][<next rid>]
+ *      +<rid>{<value>|<value>|...}
+ *      -<rid>{<value>|<value>|...}
+ *      ...
+ *
+ * The first line is the header; every following line is one record. A
+ * leading '+' marks a live record and '-' a deleted one (a tombstone). The
+ * type of a column is its number (see table.go); a decimal also records its
+ * precision and scale, as in [Amount:6(10,2)].
+ *
+ * Version:
+ *      1:  original format without the [v1] marker and without a size.
+ *          Values are stored verbatim and so cannot contain '|', '{', '}'
+ *          or newlines.
+ *      2:  values are escaped with escapeValue: a backslash followed by
+ *          one of '\', '|', '{', '}', 'n' or 'r'. No size.
+ *      3:  as version 2, but the record count and the file size are
+ *          zero-padded to countWidth digits. The header therefore never
+ *          changes length, and an insert can append its record and then
+ *          update the header in place.
+ *      4:  as version 3, but every record starts with a status ('+' or
+ *          '-') and a row id that never changes. Ids are assigned from the
+ *          next rid field of the header, also padded to countWidth digits.
+ *          A delete flips the status of its record in place and the record
+ *          count only counts live records. Older files have no stored ids;
+ *          the id of a record is its position, which is kept when the file
+ *          is upgraded.
+**/
+var currentVersion int = 4
+var countWidth int = 20
+
+// header is the parsed header of either format. Version numbers are per
+// format; pages and changes are only used by the page format (see page.go).
+type header struct {
+	format   Format
+	version  int
+	columns  []Column
+	records  int
+	size     int64
+	next_rid int
+	pages    int
+	changes  int
+}
+
+func parseHeader(line string) (header, error) {
+	var result header
+
+	if len(line) < 2 || line[0] != '[' || line[len(line)-1] != ']' {
+		return result, errors.New("expected header of the form [N][name:type]...[count]")
+	}
+
+	var fields []string = strings.Split(line[1:len(line)-1], "][")
+	result.version = 1
+
+	if len(fields[0]) > 0 && fields[0][0] == 'v' {
+		var err error
+		result.version, err = strconv.Atoi(fields[0][1:])
+		if err != nil || result.version < 2 || result.version > currentVersion {
+			return result, errors.New("unknown file format version: " + fields[0])
+		}
+
+		fields = fields[1:]
+	}
+
+	if result.version >= 4 {
+		if len(fields) < 4 {
+			return result, errors.New("header is missing the next row id")
+		}
+
+		next_rid, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return result, errors.New("cannot parse next row id as integer: " + err.Error())
+		}
+
+		result.next_rid = next_rid
+		fields = fields[:len(fields)-1]
+	}
+
+	if result.version >= 3 {
+		if len(fields) < 3 {
+			return result, errors.New("header is missing the file size")
+		}
+
+		size, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			return result, errors.New("cannot parse file size as integer: " + err.Error())
+		}
+
+		result.size = size
+		fields = fields[:len(fields)-1]
+	}
+
+	var err error
+	result.columns, result.records, err = parseHeaderFields(fields)
+	return result, err
+}
+
+func parseHeaderFields(header []string) ([]Column, int, error) {
+	if len(header) < 2 {
+		return nil, 0, errors.New("header is missing the column or record count")
+	}
+
+	columns, err := strconv.Atoi(header[0])
+	if err != nil {
+		return nil, 0, errors.New("cannot parse column count as integer: " + err.Error())
+	}
+
+	if len(header)-2 != columns {
+		return nil, 0, errors.New("number of columns does not match header column count: " + strconv.Itoa(len(header)-2) + " != " + strconv.Itoa(columns))
+	}
+
+	records, err := strconv.Atoi(header[len(header)-1])
+	if err != nil {
+		return nil, 0, errors.New("cannot parse record count as integer: " + err.Error())
+	}
+
+	var result []Column
+	for i := 1; i < len(header)-1; i++ {
+		var item []string = strings.Split(header[i], ":")
+		if len(item) != 2 {
+			return nil, 0, errors.New("expected two attributes in column " + strconv.Itoa(i) + ": got " + strconv.Itoa(len(item)))
+		}
+
+		column, err := parseColumnType(item[0], item[1])
+		if err != nil {
+			return nil, 0, errors.New("in column " + strconv.Itoa(i) + ": cannot parse `" + item[1] + "` as a column type")
+		}
+
+		result = append(result, column)
+	}
+
+	return result, records, nil
+}
+
+// parseColumnType parses the type of a column in the header.
+func parseColumnType(name string, text string) (Column, error) {
+	var result Column = Column{Name: name}
+	var parameters string
+
+	if open := strings.Index(text, "("); open != -1 && strings.HasSuffix(text, ")") {
+		text, parameters = text[:open], text[open+1:len(text)-1]
+	}
+
+	attribute_type, err := strconv.Atoi(text)
+	if err != nil {
+		return result, err
+	}
+	result.Type = ColumnType(attribute_type)
+
+	if result.Type == Decimal {
+		var digits []string = strings.Split(parameters, ",")
+		if len(digits) != 2 {
+			return result, errors.New("a decimal needs a precision and a scale")
+		}
+
+		result.Precision, err = strconv.Atoi(digits[0])
+		if err != nil {
+			return result, err
+		}
+
+		result.Scale, err = strconv.Atoi(digits[1])
+		if err != nil {
+			return result, err
+		}
+	} else if len(parameters) > 0 {
+		return result, errors.New("only a decimal has a precision and scale")
+	}
+
+	return result, ValidateColumnType(result)
+}
+
+func formatColumnType(column Column) string {
+	var result string = strconv.Itoa(int(column.Type))
+	if column.Type == Decimal {
+		result += "(" + strconv.Itoa(column.Precision) + "," + strconv.Itoa(column.Scale) + ")"
+	}
+	return result
+}
+
+func formatHeader(h header) string {
+	var result string
+	if h.version > 1 {
+		result += "[v" + strconv.Itoa(h.version) + "]"
+	}
+	result += "[" + strconv.Itoa(len(h.columns)) + "]"
+
+	for i := range h.columns {
+		result += "[" + h.columns[i].Name + ":" + formatColumnType(h.columns[i]) + "]"
+	}
+
+	if h.version >= 4 {
+		result += "[" + padCount(int64(h.records)) + "][" + padCount(h.size) + "][" + padCount(int64(h.next_rid)) + "]"
+	} else if h.version >= 3 {
+		result += "[" + padCount(int64(h.records)) + "][" + padCount(h.size) + "]"
+	} else {
+		result += "[" + strconv.Itoa(h.records) + "]"
+	}
+
+	return result
+}
+
+func padCount(value int64) string {
+	var result string = strconv.FormatInt(value, 10)
+	for len(result) < countWidth {
+		result = "0" + result
+	}
+	return result
+}
+
+// parseRecord parses a record line. Versions before 4 have no stored id or
+// status; they return a row id of -1 and are always live.
+func parseRecord(line string, columns int, version int) (int, bool, []string, error) {
+	if version < 4 {
+		values, err := parseRow(line, columns, version)
+		return -1, true, values, err
+	}
+
+	var brace int = strings.IndexByte(line, '{')
+	if brace < 2 || (line[0] != '+' && line[0] != '-') {
+		return -1, false, nil, errors.New("expected record of the form +rid{value|value|...}")
+	}
+
+	row_id, err := strconv.Atoi(line[1:brace])
+	if err != nil || row_id < 0 {
+		return -1, false, nil, errors.New("cannot parse row id `" + line[1:brace] + "`")
+	}
+
+	values, err := parseRow(line[brace:], columns, version)
+	return row_id, line[0] == '+', values, err
+}
+
+func formatRecord(row_id int, values []string) string {
+	return "+" + strconv.Itoa(row_id) + formatRow(values, currentVersion)
+}
+
+func parseRow(line string, columns int, version int) ([]string, error) {
+	if len(line) < 2 || line[0] != '{' || line[len(line)-1] != '}' {
+		return nil, errors.New("expected record of the form {value|value|...}")
+	}
+
+	var result []string
+	if version == 1 {
+		result = strings.Split(line[1:len(line)-1], "|")
+	} else {
+		var err error
+		result, err = splitEscaped(line[1 : len(line)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result) != columns {
+		return nil, errors.New("mismatched number of columns: have " + strconv.Itoa(len(result)) + ", expected " + strconv.Itoa(columns))
+	}
+
+	return result, nil
+}
+
+func formatRow(values []string, version int) string {
+	if version == 1 {
+		return "{" + strings.Join(values, "|") + "}"
+	}
+
+	var escaped []string = make([]string, len(values))
+	for i := range values {
+		escaped[i] = escapeValue(values[i])
+	}
+
+	return "{" + strings.Join(escaped, "|") + "}"
+}
+
+var escapeReplacer *strings.Replacer = strings.NewReplacer("\\", "\\\\", "|", "\\|", "{", "\\{", "}", "\\}", "\n", "\\n", "\r", "\\r")
+
+func escapeValue(value string) string {
+	return escapeReplacer.Replace(value)
+}
+
+// splitEscaped splits the inside of a version 2 record on unescaped '|' and
+// decodes the escape sequences in each value.
+func splitEscaped(line string) ([]string, error) {
+	var result []string
+	var current []byte
+
+	for i := 0; i < len(line); i++ {
+		if line[i] == '|' {
+			result = append(result, string(current))
+			current = current[:0]
+		} else if line[i] == '{' || line[i] == '}' {
+			return nil, errors.New("unescaped `" + string(line[i]) + "` at position " + strconv.Itoa(i+1))
+		} else if line[i] == '\\' {
+			if i+1 >= len(line) {
+				return nil, errors.New("unterminated escape sequence at end of record")
+			}
+
+			i += 1
+			switch line[i] {
+			case '\\', '|', '{', '}':
+				current = append(current, line[i])
+			case 'n':
+				current = append(current, '\n')
+			case 'r':
+				current = append(current, '\r')
+			default:
+				return nil, errors.New("unknown escape sequence `\\" + string(line[i]) + "` at position " + strconv.Itoa(i))
+			}
+		} else {
+			current = append(current, line[i])
+		}
+	}
+
+	result = append(result, string(current))
+	return result, nil
+}
@@ -0,0 +1,801 @@
+package table
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+/**
+ * Secondary indexes. An index on the column at position n (counting from 1,
+ * as header prints them) is a B+tree in <filename>.<n>.idx, built from the
+ * table by CreateIndex and kept current by Insert, Update and Delete.
+ *
+ * The index file is a sequence of pages of pageSize bytes; all integers are
+ * big-endian. Page 0 is the header:
+ *      magic       8 bytes, "PETINDEX"
+ *      version     u32
+ *      page size   u32
+ *      pages       u32, including the header page
+ *      root        u32, page number of the root node
+ *      column      u16, position of the column in the table, from 0
+ *      type        u8, column type
+ *      entries     u64
+ *      records     u64, the table's record count when last updated
+ *      next rid    u64, the table's next rid when last updated
+ *      size        u64, the table's size when last updated
+ *      changes     u64, the table's change count when last updated (see
+ *                  page.go); version 2 only
+ *
+ * Every other page is a node:
+ *      kind        u8, 1 for a leaf and 2 for an internal node
+ *      entries     u16
+ *      next        u32, the next leaf to the right, or 0
+ *      child       u32, internal nodes only: the leftmost child
+ *      for each entry:
+ *          length  u16
+ *          key     <length> bytes
+ *          rid     u64
+ *          leaves:     location, u64 (see recordReader)
+ *          internal:   child, u32, holding entries >= this one
+ *
+ * Keys are encoded so that bytes.Compare orders them like their values, and
+ * entries are ordered by key and then row id, so every entry is unique.
+ * Strings longer than maxKeySize are truncated. An index lookup therefore
+ * returns a superset of the matching rows, and Search still evaluates the
+ * query against every row it reads. Deleted entries are removed from their
+ * leaf; nodes are never merged.
+ *
+ * The counts, size and change count of the table are copied into the index
+ * header after every update. An index whose copy does not match the table,
+ * for example because pet crashed between updating the table and its index,
+ * is stale: Search ignores it and the next Insert, Update or Delete
+ * rebuilds it. Version 1 indexes have no change count and are read as
+ * matching no changes.
+**/
+var indexMagic []byte = []byte("PETINDEX")
+var indexVersion int = 2
+var maxKeySize int = 512
+
+var leafNodeKind byte = 1
+var internalNodeKind byte = 2
+
+type indexHeader struct {
+	pages    int
+	root     int
+	column   int
+	key_type ColumnType
+	entries  int
+	records  int
+	next_rid int
+	size     int64
+	changes  int
+}
+
+type indexEntry struct {
+	key      []byte
+	rid      int
+	location int64
+	child    int
+}
+
+type indexNode struct {
+	leaf    bool
+	next    int
+	first   int
+	entries []indexEntry
+}
+
+type index struct {
+	file  *os.File
+	h     indexHeader
+	dirty map[int]*indexNode
+}
+
+// indexable reports whether a column of this type can be indexed.
+func indexable(column_type ColumnType) bool {
+	return column_type == Integer || column_type == Double || column_type == String
+}
+
+// indexKey encodes a value so that byte order matches value order.
+func indexKey(column_type ColumnType, value string) ([]byte, error) {
+	var result []byte = make([]byte, 8)
+
+	switch column_type {
+	case Integer:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(result, uint64(number)^(1<<63))
+	case Double:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		// -0 equals 0, so both must have the same key.
+		if number == 0 {
+			number = 0
+		}
+
+		var bits uint64 = math.Float64bits(number)
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		binary.BigEndian.PutUint64(result, bits)
+	case String:
+		result = []byte(value)
+		if len(result) > maxKeySize {
+			result = result[:maxKeySize]
+		}
+	default:
+		return nil, errors.New("cannot index a column of type " + column_type.String())
+	}
+
+	return result, nil
+}
+
+func compareEntries(a indexEntry, b indexEntry) int {
+	var result int = bytes.Compare(a.key, b.key)
+	if result != 0 {
+		return result
+	}
+
+	if a.rid < b.rid {
+		return -1
+	} else if a.rid > b.rid {
+		return 1
+	}
+
+	return 0
+}
+
+func (t *Table) indexName(column int) string {
+	return t.filename + "." + strconv.Itoa(column+1) + ".idx"
+}
+
+// Indexes returns the names of the indexed columns.
+func (t *Table) Indexes() []string {
+	var result []string
+	for i := range t.columns {
+		if _, err := os.Stat(t.indexName(i)); err == nil {
+			result = append(result, t.columns[i].Name)
+		}
+	}
+
+	return result
+}
+
+// CreateIndex builds an index over an integer, double or string column.
+func (t *Table) CreateIndex(column string) error {
+	var position int = t.columnIndex(column)
+	if position == -1 {
+		return &SchemaError{Reason: "unknown attribute `" + column + "`"}
+	}
+
+	if !indexable(t.columns[position].Type) {
+		return &SchemaError{Reason: "cannot index attribute `" + column + "` of type " + t.columns[position].Type.String() + "; only integer, double and string attributes can be indexed"}
+	}
+
+	l, err := t.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+
+	if _, err := os.Stat(t.indexName(position)); err == nil {
+		return &IndexError{Column: column, Reason: "index already exists"}
+	}
+
+	err = t.buildIndex(position)
+	if err != nil {
+		return &IndexError{Column: column, Reason: err.Error()}
+	}
+
+	return nil
+}
+
+// buildIndex writes a complete index for the column from the table
+// contents, replacing any existing one. The caller must hold an exclusive
+// lock on the table.
+func (t *Table) buildIndex(column int) error {
+	f, err := os.Open(t.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := newRecordReader(f, t.filename, t.header)
+	if err != nil {
+		return err
+	}
+
+	var entries []indexEntry
+	for {
+		row_id, live, values, err := records.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if !live {
+			continue
+		}
+
+		key, err := indexKey(t.columns[column].Type, values[column])
+		if err != nil {
+			return &RowError{RID: row_id, Reason: err.Error()}
+		}
+
+		entries = append(entries, indexEntry{key: key, rid: row_id, location: records.location()})
+	}
+
+	sort.Slice(entries, func(i int, j int) bool { return compareEntries(entries[i], entries[j]) < 0 })
+
+	var h indexHeader = indexHeader{pages: 1, column: column, key_type: t.columns[column].Type, entries: len(entries)}
+	h.records, h.next_rid, h.size, h.changes = t.records, t.next_rid, t.size, t.changes
+
+	var pages [][]byte = [][]byte{nil}
+	var level []indexEntry
+
+	// Pack the sorted entries into full leaves, and then each level of
+	// children into internal nodes, until a single root remains.
+	var leaf *indexNode = &indexNode{leaf: true}
+	for i := range entries {
+		leaf.entries = append(leaf.entries, entries[i])
+		if leaf.size() > pageSize {
+			leaf.entries = leaf.entries[:len(leaf.entries)-1]
+			leaf.next = len(pages) + 1
+			level = append(level, indexEntry{key: leaf.entries[0].key, rid: leaf.entries[0].rid, child: len(pages)})
+			pages = append(pages, leaf.encode())
+			leaf = &indexNode{leaf: true, entries: []indexEntry{entries[i]}}
+		}
+	}
+
+	if len(leaf.entries) > 0 {
+		level = append(level, indexEntry{key: leaf.entries[0].key, rid: leaf.entries[0].rid, child: len(pages)})
+	} else {
+		level = append(level, indexEntry{child: len(pages)})
+	}
+	pages = append(pages, leaf.encode())
+
+	for len(level) > 1 {
+		var parents []indexEntry
+		var node *indexNode = &indexNode{first: level[0].child}
+		var first indexEntry = level[0]
+
+		for i := 1; i < len(level); i++ {
+			node.entries = append(node.entries, level[i])
+			if node.size() > pageSize {
+				node.entries = node.entries[:len(node.entries)-1]
+				parents = append(parents, indexEntry{key: first.key, rid: first.rid, child: len(pages)})
+				pages = append(pages, node.encode())
+				node = &indexNode{first: level[i].child}
+				first = level[i]
+			}
+		}
+
+		parents = append(parents, indexEntry{key: first.key, rid: first.rid, child: len(pages)})
+		pages = append(pages, node.encode())
+		level = parents
+	}
+
+	h.root = level[0].child
+	h.pages = len(pages)
+	pages[0] = h.encode()
+
+	return writeIndexFile(t.indexName(column), pages)
+}
+
+// writeIndexFile atomically replaces an index file.
+func writeIndexFile(filename string, pages [][]byte) error {
+	var temp_name string = filename + ".tmp"
+
+	fw, err := os.OpenFile(temp_name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	for i := range pages {
+		if err == nil {
+			_, err = fw.Write(pages[i])
+		}
+	}
+
+	if err == nil {
+		err = fw.Sync()
+	}
+
+	var close_err error = fw.Close()
+	if err == nil {
+		err = close_err
+	}
+
+	if err == nil {
+		err = os.Rename(temp_name, filename)
+	}
+
+	if err != nil {
+		os.Remove(temp_name)
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+// openIndex opens the index on a column, returning nil if there is none.
+func (t *Table) openIndex(column int, writable bool) (*index, error) {
+	var flags int = os.O_RDONLY
+	if writable {
+		flags = os.O_RDWR
+	}
+
+	f, err := os.OpenFile(t.indexName(column), flags, 0666)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	page, err := readPage(f, 0)
+	if err == nil {
+		var h indexHeader
+		h, err = decodeIndexHeader(page)
+		if err == nil {
+			var result *index = &index{file: f, h: h, dirty: map[int]*indexNode{}}
+			return result, nil
+		}
+	}
+
+	f.Close()
+	return nil, err
+}
+
+// current reports whether the index was last updated for this table header.
+func (x *index) current(t *Table, h header) bool {
+	if x.h.column >= len(t.columns) || x.h.key_type != t.columns[x.h.column].Type {
+		return false
+	}
+
+	return x.h.records == h.records && x.h.next_rid == h.next_rid && x.h.size == h.size && x.h.changes == h.changes
+}
+
+func (x *index) close() error {
+	return x.file.Close()
+}
+
+func (x *index) node(number int) (*indexNode, error) {
+	if node, ok := x.dirty[number]; ok {
+		return node, nil
+	}
+
+	if number < 1 || number >= x.h.pages {
+		return nil, errors.New("node " + strconv.Itoa(number) + " lies outside the index")
+	}
+
+	page, err := readPage(x.file, number)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeIndexNode(page)
+}
+
+// lookup returns the entries with keys between low and high inclusive, in
+// key order. A nil bound is unbounded.
+func (x *index) lookup(low []byte, high []byte) ([]indexEntry, error) {
+	node, err := x.node(x.h.root)
+	if err != nil {
+		return nil, err
+	}
+
+	for !node.leaf {
+		var child int = node.first
+		for i := range node.entries {
+			if low == nil || bytes.Compare(node.entries[i].key, low) >= 0 {
+				break
+			}
+			child = node.entries[i].child
+		}
+
+		node, err = x.node(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result []indexEntry
+	for {
+		for i := range node.entries {
+			if low != nil && bytes.Compare(node.entries[i].key, low) < 0 {
+				continue
+			}
+
+			if high != nil && bytes.Compare(node.entries[i].key, high) > 0 {
+				return result, nil
+			}
+
+			result = append(result, node.entries[i])
+		}
+
+		if node.next == 0 {
+			return result, nil
+		}
+
+		node, err = x.node(node.next)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// path returns the nodes from the root to the leaf which holds, or would
+// hold, the entry, along with the page number of each.
+func (x *index) path(entry indexEntry) ([]*indexNode, []int, error) {
+	var nodes []*indexNode
+	var numbers []int
+
+	var number int = x.h.root
+	for {
+		node, err := x.node(number)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		nodes = append(nodes, node)
+		numbers = append(numbers, number)
+		if node.leaf {
+			return nodes, numbers, nil
+		}
+
+		number = node.first
+		for i := range node.entries {
+			if compareEntries(node.entries[i], entry) > 0 {
+				break
+			}
+			number = node.entries[i].child
+		}
+	}
+}
+
+func (x *index) insert(entry indexEntry) error {
+	nodes, numbers, err := x.path(entry)
+	if err != nil {
+		return err
+	}
+
+	var position int = len(nodes) - 1
+	var pending indexEntry = entry
+
+	for {
+		var node *indexNode = nodes[position]
+		var at int = sort.Search(len(node.entries), func(i int) bool { return compareEntries(node.entries[i], pending) > 0 })
+
+		node.entries = append(node.entries, indexEntry{})
+		copy(node.entries[at+1:], node.entries[at:])
+		node.entries[at] = pending
+		x.dirty[numbers[position]] = node
+
+		if node.size() <= pageSize {
+			break
+		}
+
+		// Split the node roughly in half by size, and pass the first
+		// entry of the right half up to the parent.
+		var right *indexNode = &indexNode{leaf: node.leaf}
+		var right_number int = x.h.pages
+		x.h.pages += 1
+
+		var split int = node.split()
+		if node.leaf {
+			right.entries = append(right.entries, node.entries[split:]...)
+			right.next = node.next
+			node.next = right_number
+			pending = indexEntry{key: right.entries[0].key, rid: right.entries[0].rid, child: right_number}
+		} else {
+			right.first = node.entries[split].child
+			right.entries = append(right.entries, node.entries[split+1:]...)
+			pending = indexEntry{key: node.entries[split].key, rid: node.entries[split].rid, child: right_number}
+		}
+		node.entries = node.entries[:split]
+		x.dirty[right_number] = right
+
+		if position == 0 {
+			var root *indexNode = &indexNode{first: numbers[0], entries: []indexEntry{pending}}
+			x.h.root = x.h.pages
+			x.h.pages += 1
+			x.dirty[x.h.root] = root
+			break
+		}
+
+		position -= 1
+	}
+
+	x.h.entries += 1
+	return nil
+}
+
+func (x *index) remove(entry indexEntry) error {
+	nodes, numbers, err := x.path(entry)
+	if err != nil {
+		return err
+	}
+
+	var leaf *indexNode = nodes[len(nodes)-1]
+	for i := range leaf.entries {
+		if compareEntries(leaf.entries[i], entry) == 0 {
+			leaf.entries = append(leaf.entries[:i], leaf.entries[i+1:]...)
+			x.dirty[numbers[len(numbers)-1]] = leaf
+			x.h.entries -= 1
+			return nil
+		}
+	}
+
+	return errors.New("row " + strconv.Itoa(entry.rid) + " is missing from the index")
+}
+
+// flush writes every changed node and then the header, which records the
+// table state the index now matches.
+func (x *index) flush(h header) error {
+	for number, node := range x.dirty {
+		_, err := x.file.WriteAt(node.encode(), int64(number)*int64(pageSize))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := x.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	x.h.records, x.h.next_rid, x.h.size, x.h.changes = h.records, h.next_rid, h.size, h.changes
+	_, err = x.file.WriteAt(x.h.encode(), 0)
+	if err != nil {
+		return err
+	}
+
+	x.dirty = map[int]*indexNode{}
+	return x.file.Sync()
+}
+
+// An indexChange adds or removes the entry of one record in every index.
+type indexChange struct {
+	row_id   int
+	values   []string
+	location int64
+	inserted bool
+}
+
+// updateIndexes applies an insert or delete of one record to every index
+// of the table, which must already hold the change. A location of -1 means
+// the table was rewritten, and the indexes are rebuilt instead; so is any
+// index which was stale before the change. The caller must hold an
+// exclusive lock on the table.
+func (t *Table) updateIndexes(before header, row_id int, values []string, location int64, inserted bool) error {
+	return t.changeIndexes(before, []indexChange{{row_id: row_id, values: values, location: location, inserted: inserted}})
+}
+
+// replaceIndexes applies an update of one record, which moved it from
+// old_location to location, to every index of the table. Both entries change
+// under a single flush, so an index is never left holding only one of them.
+// As with updateIndexes, a location of -1 rebuilds the indexes instead.
+func (t *Table) replaceIndexes(before header, row_id int, old_values []string, old_location int64, values []string, location int64) error {
+	return t.changeIndexes(before, []indexChange{{row_id: row_id, values: old_values, location: old_location}, {row_id: row_id, values: values, location: location, inserted: true}})
+}
+
+// changeIndexes applies the changes, in order, to every index of the table
+// under a single flush. If any location is -1, the indexes are rebuilt.
+func (t *Table) changeIndexes(before header, changes []indexChange) error {
+	var rewritten bool = false
+	for i := range changes {
+		if changes[i].location == -1 {
+			rewritten = true
+		}
+	}
+
+	for column := range t.columns {
+		x, err := t.openIndex(column, true)
+		if err != nil {
+			return &IndexError{Column: t.columns[column].Name, Reason: err.Error()}
+		} else if x == nil {
+			continue
+		}
+
+		if !rewritten && x.current(t, before) {
+			for i := 0; i < len(changes) && err == nil; i++ {
+				var entry indexEntry = indexEntry{rid: changes[i].row_id, location: changes[i].location}
+				entry.key, err = indexKey(t.columns[column].Type, changes[i].values[column])
+				if err == nil && changes[i].inserted {
+					err = x.insert(entry)
+				} else if err == nil {
+					err = x.remove(entry)
+				}
+			}
+
+			if err == nil {
+				err = x.flush(t.header)
+			}
+			x.close()
+		} else {
+			x.close()
+			err = t.buildIndex(column)
+		}
+
+		if err != nil {
+			return &IndexError{Column: t.columns[column].Name, Reason: err.Error() + "; the change was saved and the index will be rebuilt by the next insert, update or delete"}
+		}
+	}
+
+	return nil
+}
+
+func (h indexHeader) encode() []byte {
+	var result []byte = make([]byte, pageSize)
+	copy(result, indexMagic)
+	binary.BigEndian.PutUint32(result[8:], uint32(indexVersion))
+	binary.BigEndian.PutUint32(result[12:], uint32(pageSize))
+	binary.BigEndian.PutUint32(result[16:], uint32(h.pages))
+	binary.BigEndian.PutUint32(result[20:], uint32(h.root))
+	binary.BigEndian.PutUint16(result[24:], uint16(h.column))
+	result[26] = byte(h.key_type)
+	binary.BigEndian.PutUint64(result[27:], uint64(h.entries))
+	binary.BigEndian.PutUint64(result[35:], uint64(h.records))
+	binary.BigEndian.PutUint64(result[43:], uint64(h.next_rid))
+	binary.BigEndian.PutUint64(result[51:], uint64(h.size))
+	binary.BigEndian.PutUint64(result[59:], uint64(h.changes))
+	return result
+}
+
+func decodeIndexHeader(page []byte) (indexHeader, error) {
+	var result indexHeader
+
+	if !bytes.Equal(page[0:8], indexMagic) {
+		return result, errors.New("missing index magic")
+	}
+
+	var version int = int(binary.BigEndian.Uint32(page[8:]))
+	if version < 1 || version > indexVersion {
+		return result, errors.New("unknown index version: " + strconv.Itoa(version))
+	}
+
+	if int(binary.BigEndian.Uint32(page[12:])) != pageSize {
+		return result, errors.New("unsupported page size: " + strconv.Itoa(int(binary.BigEndian.Uint32(page[12:]))))
+	}
+
+	result.pages = int(binary.BigEndian.Uint32(page[16:]))
+	result.root = int(binary.BigEndian.Uint32(page[20:]))
+	result.column = int(binary.BigEndian.Uint16(page[24:]))
+	result.key_type = ColumnType(page[26])
+	result.entries = int(binary.BigEndian.Uint64(page[27:]))
+	result.records = int(binary.BigEndian.Uint64(page[35:]))
+	result.next_rid = int(binary.BigEndian.Uint64(page[43:]))
+	result.size = int64(binary.BigEndian.Uint64(page[51:]))
+	if version >= 2 {
+		result.changes = int(binary.BigEndian.Uint64(page[59:]))
+	}
+	return result, nil
+}
+
+// size returns the encoded size of the node in bytes.
+func (n *indexNode) size() int {
+	var result int = 7
+	var value int = 8
+	if !n.leaf {
+		result += 4
+		value = 4
+	}
+
+	for i := range n.entries {
+		result += 2 + len(n.entries[i].key) + 8 + value
+	}
+
+	return result
+}
+
+// split returns the position at which to split an overfull node so that
+// both halves fit in a page.
+func (n *indexNode) split() int {
+	var total int = n.size()
+	var left int = 0
+	for i := range n.entries {
+		left += 2 + len(n.entries[i].key) + 16
+		if left >= total/2 && i+1 < len(n.entries) {
+			return i + 1
+		}
+	}
+
+	return len(n.entries) - 1
+}
+
+func (n *indexNode) encode() []byte {
+	var result []byte = make([]byte, pageSize)
+	var offset int = 7
+
+	result[0] = leafNodeKind
+	if !n.leaf {
+		result[0] = internalNodeKind
+		binary.BigEndian.PutUint32(result[7:], uint32(n.first))
+		offset += 4
+	}
+	binary.BigEndian.PutUint16(result[1:], uint16(len(n.entries)))
+	binary.BigEndian.PutUint32(result[3:], uint32(n.next))
+
+	for i := range n.entries {
+		var entry indexEntry = n.entries[i]
+		binary.BigEndian.PutUint16(result[offset:], uint16(len(entry.key)))
+		copy(result[offset+2:], entry.key)
+		offset += 2 + len(entry.key)
+
+		binary.BigEndian.PutUint64(result[offset:], uint64(entry.rid))
+		offset += 8
+
+		if n.leaf {
+			binary.BigEndian.PutUint64(result[offset:], uint64(entry.location))
+			offset += 8
+		} else {
+			binary.BigEndian.PutUint32(result[offset:], uint32(entry.child))
+			offset += 4
+		}
+	}
+
+	return result
+}
+
+func decodeIndexNode(page []byte) (*indexNode, error) {
+	var truncated error = errors.New("truncated index node")
+	var result *indexNode = &indexNode{}
+	var offset int = 7
+
+	if page[0] == leafNodeKind {
+		result.leaf = true
+	} else if page[0] == internalNodeKind {
+		result.first = int(binary.BigEndian.Uint32(page[7:]))
+		offset += 4
+	} else {
+		return nil, errors.New("unknown index node kind " + strconv.Itoa(int(page[0])))
+	}
+
+	var count int = int(binary.BigEndian.Uint16(page[1:]))
+	result.next = int(binary.BigEndian.Uint32(page[3:]))
+
+	for i := 0; i < count; i++ {
+		var entry indexEntry
+		if offset+2 > len(page) {
+			return nil, truncated
+		}
+
+		var length int = int(binary.BigEndian.Uint16(page[offset:]))
+		if offset+2+length+16 > len(page) {
+			return nil, truncated
+		}
+
+		entry.key = append([]byte(nil), page[offset+2:offset+2+length]...)
+		offset += 2 + length
+
+		entry.rid = int(binary.BigEndian.Uint64(page[offset:]))
+		offset += 8
+
+		if result.leaf {
+			entry.location = int64(binary.BigEndian.Uint64(page[offset:]))
+			offset += 8
+		} else {
+			entry.child = int(binary.BigEndian.Uint32(page[offset:]))
+			offset += 4
+		}
+
+		result.entries = append(result.entries, entry)
+	}
+
+	return result, nil
+}
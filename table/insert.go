@@ -0,0 +1,147 @@
+package table
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseValue validates user input for a column and returns the value as it
+// should be stored.
+func ParseValue(column Column, input string) (string, error) {
+	var value string = strings.Trim(input, " \n\t")
+
+	switch column.Type {
+	case Integer:
+		_, err := strconv.Atoi(value)
+		if err != nil && err.(*strconv.NumError).Err == strconv.ErrRange {
+			return "", &ValueError{Column: column, Value: value, Reason: "out of range for an integer; use a bigint or decimal attribute for larger numbers"}
+		} else if err != nil {
+			return "", &ValueError{Column: column, Value: value, Reason: "unable to convert input to integer: " + err.Error()}
+		}
+	case Double:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", &ValueError{Column: column, Value: value, Reason: "unable to convert input to double: " + err.Error()}
+		} else if math.IsNaN(number) || math.IsInf(number, 0) {
+			// Neither has a place in the order of search, sorts and indexes.
+			return "", &ValueError{Column: column, Value: value, Reason: "a double must be a finite number"}
+		}
+	case Boolean:
+		value = strings.ToUpper(value)
+		if value != "T" && value != "F" {
+			return "", &ValueError{Column: column, Value: value, Reason: "unknown boolean value: expected either T or F"}
+		}
+	case String:
+	case BigInteger:
+		return parseBigIntegerValue(column, value)
+	case Decimal:
+		return parseDecimalValue(column, value)
+	default:
+		return "", &ValueError{Column: column, Value: value, Reason: "unknown column type"}
+	}
+
+	return value, nil
+}
+
+func (t *Table) Insert(values []string) error {
+	if len(values) != len(t.columns) {
+		return &SchemaError{Reason: "expected " + strconv.Itoa(len(t.columns)) + " values but got " + strconv.Itoa(len(values))}
+	}
+
+	l, err := t.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+
+	var record_data []string
+	for i := range t.columns {
+		value, err := ParseValue(t.columns[i], values[i])
+		if err != nil {
+			return err
+		}
+
+		record_data = append(record_data, value)
+	}
+
+	var before header = t.header
+
+	if t.format == PageFormat {
+		location, err := t.insertPages(record_data)
+		if err != nil {
+			return err
+		}
+
+		return t.updateIndexes(before, before.next_rid, record_data, location, true)
+	}
+
+	if t.version == currentVersion {
+		var line string = formatRecord(t.next_rid, record_data)
+		var after header = appendHeader(t.header, line)
+
+		err = t.commit(&journal{op: "append", before: formatHeader(t.header), after: formatHeader(after), rid: t.next_rid, row: line})
+		if err != nil {
+			return err
+		}
+
+		return t.updateIndexes(before, before.next_rid, record_data, before.size, true)
+	}
+
+	// Older formats are upgraded with a full rewrite; later inserts append.
+	before_line, err := readHeaderLine(t.filename)
+	if err != nil {
+		return err
+	}
+
+	lines, err := readLines(t.filename, t.header)
+	if err != nil {
+		return err
+	}
+
+	var line string = formatRecord(len(lines), record_data)
+	lines = append(lines, line)
+
+	var after header = rewriteHeader(t.header, lines)
+	after.next_rid = len(lines)
+
+	err = t.commit(&journal{op: "upgrade", before: before_line, after: formatHeader(after), rid: len(lines) - 1, row: line})
+	if err != nil {
+		return err
+	}
+
+	// The rewrite moved every record, so the indexes are rebuilt.
+	return t.updateIndexes(before, len(lines)-1, record_data, -1, true)
+}
+
+// InsertRows inserts several rows at once. Every row is checked before any
+// is inserted, and all of them are inserted in a single journaled mutation
+// (see change.go).
+func (t *Table) InsertRows(rows [][]string) error {
+	l, err := t.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+
+	var records [][]string
+	for i := range rows {
+		if len(rows[i]) != len(t.columns) {
+			return &SchemaError{Reason: "row " + strconv.Itoa(i+1) + ": expected " + strconv.Itoa(len(t.columns)) + " values but got " + strconv.Itoa(len(rows[i]))}
+		}
+
+		var record_data []string
+		for j := range t.columns {
+			value, err := ParseValue(t.columns[j], rows[i][j])
+			if err != nil {
+				return err
+			}
+
+			record_data = append(record_data, value)
+		}
+
+		records = append(records, record_data)
+	}
+
+	return t.changeRows(records, nil, nil)
+}
@@ -0,0 +1,498 @@
+package table
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/**
+ * A Join combines the rows of two tables. The columns of the joined rows are
+ * those of the left table followed by those of the right, named
+ * `table.column`, where a table is named after its file without the
+ * directory or the .tb extension unless JoinOptions names it. Conditions
+ * and lists of columns may leave out the table of a column which only one
+ * of the tables has.
+ *
+ * The ON condition decides which pairs of rows join. The right table is read
+ * into memory once and the left table is then streamed, trying right rows
+ * against each left row:
+ *
+ *      hash join:          when ON is an equality between a column of each
+ *                          table, such as `abc.SSN = pay.SSN`, or a
+ *                          conjunction (&) of conditions containing any,
+ *                          the right rows are hashed on those columns and
+ *                          a left row only tries those with the same values.
+ *      nested-loop join:   otherwise, a left row tries every right row.
+ *
+ * Either way, the whole ON condition decides whether a pair tried joins. An
+ * inner join returns the joined pairs, in the order of the left table and
+ * then of the right. A left join also returns every left row which joined
+ * no right row, with empty values for the columns of the right table.
+ *
+ * The WHERE query then filters the joined rows, and may order and page
+ * through them like a search. A condition which cannot be evaluated on the
+ * empty values of a missing right row is false, and empty values come first
+ * in ORDER BY.
+**/
+type JoinKind int
+
+const (
+	InnerJoin JoinKind = iota
+	LeftJoin
+)
+
+var joinKindNames map[JoinKind]string = map[JoinKind]string{InnerJoin: "inner", LeftJoin: "left"}
+
+func (k JoinKind) String() string {
+	name, ok := joinKindNames[k]
+	if !ok {
+		return "unknown"
+	}
+	return name
+}
+
+// JoinOptions choose the kind of join, the names which qualify the columns
+// of each table, empty for the default, and how strings compare.
+type JoinOptions struct {
+	Kind      JoinKind
+	LeftName  string
+	RightName string
+	QueryOptions
+}
+
+var table_name_pattern *regexp.Regexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+type Join struct {
+	left      *Table
+	right     *Table
+	kind      JoinKind
+	names     [2]string
+	columns   []Column
+	on        *queryNode
+	plan      queryPlan
+	keys      []joinKey
+	where     *Query
+	collation Collation
+	ignore    bool
+}
+
+// A joinKey is an equality of ON between a column of the left table and one
+// of the right, hashed as values of the given type.
+type joinKey struct {
+	left     int
+	right    int
+	kind     int
+	relation *queryNode
+}
+
+// TableName returns the name a table is known by in a join: its filename
+// without the directory or the .tb extension.
+func TableName(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), ".tb")
+}
+
+// ParseJoin parses and checks the ON condition and the WHERE query of a join
+// of two tables; WHERE may be empty.
+func ParseJoin(on string, where string, left *Table, right *Table, options JoinOptions) (*Join, error) {
+	if _, ok := joinKindNames[options.Kind]; !ok {
+		return nil, &SchemaError{Reason: "unknown kind of join: " + strconv.Itoa(int(options.Kind))}
+	}
+
+	var result *Join = &Join{left: left, right: right, kind: options.Kind, names: [2]string{options.LeftName, options.RightName}}
+	result.collation = options.Collation
+	result.ignore = options.IgnoreCase
+
+	var tables [2]*Table = [2]*Table{left, right}
+	for i := range tables {
+		if result.names[i] == "" {
+			result.names[i] = TableName(tables[i].Filename())
+		}
+
+		if !table_name_pattern.MatchString(result.names[i]) {
+			return nil, &SchemaError{Reason: "table name `" + result.names[i] + "` cannot qualify columns; it must be a letter or _ followed by letters, digits, _ or -"}
+		}
+
+		for _, column := range tables[i].Schema() {
+			column.Name = result.names[i] + "." + column.Name
+			result.columns = append(result.columns, column)
+		}
+	}
+
+	if result.names[0] == result.names[1] {
+		return nil, &SchemaError{Reason: "both tables are named `" + result.names[0] + "`; give one of them another name"}
+	}
+
+	var column_names []string
+	var column_types []int
+	for i := range result.columns {
+		column_names = append(column_names, result.columns[i].Name)
+		column_types = append(column_types, int(result.columns[i].Type))
+	}
+
+	root, clauses, err := parseQueryText(on)
+	if err != nil {
+		return nil, err
+	} else if root == nil || len(clauses.Group) > 0 || clauses.Having != nil || len(clauses.Order) > 0 || clauses.Limit != -1 || clauses.Offset != 0 {
+		return nil, &SchemaError{Reason: "ON must be a condition such as abc.SSN = pay.SSN; GROUP BY, HAVING, ORDER BY, LIMIT and OFFSET belong to WHERE"}
+	}
+
+	err = qualifyColumns(on, root, clauses, column_names)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkQuery(on, root, column_names, column_types)
+	if err != nil {
+		return nil, err
+	}
+
+	result.plan, err = compileQuery(root, column_types, options.QueryOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	result.on = root
+	result.keys = joinKeys(root, len(left.Schema()), column_types)
+
+	if strings.TrimSpace(where) == "" {
+		result.where = MatchAll(result.columns, options.QueryOptions)
+	} else {
+		result.where, err = parseQuery(where, result.columns, options.QueryOptions, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.where.Grouped() {
+		return nil, &SchemaError{Reason: "a join cannot group its rows; GROUP BY, HAVING and aggregates only work on a single table"}
+	}
+
+	if result.kind == LeftJoin {
+		result.where.missing = len(left.Schema())
+	}
+
+	return result, nil
+}
+
+// qualifyColumns gives the columns named in a query of a join their table,
+// where only one table has a column of that name.
+func qualifyColumns(query string, root *queryNode, clauses queryClauses, column_names []string) error {
+	var qualify = func(value *token) error {
+		return qualifyName(query, value, column_names)
+	}
+
+	var err error
+	var visit func(node *queryNode, values bool)
+	visit = func(node *queryNode, values bool) {
+		if node == nil || err != nil {
+			return
+		}
+
+		if node.Kind == relation_node_kind {
+			visit(node.Left, true)
+			visit(node.Right, true)
+		} else if node.Kind == in_node_kind || node.Kind == between_node_kind {
+			// The IN list and the bounds of BETWEEN are always values.
+			visit(node.Left, true)
+		} else if node.Kind == literal_node_kind || node.Kind == column_node_kind {
+			if values {
+				err = qualify(&node.Token)
+			}
+		} else {
+			visit(node.Left, values)
+			visit(node.Right, values)
+		}
+	}
+	visit(root, false)
+
+	for i := range clauses.Order {
+		if err == nil {
+			err = qualify(&clauses.Order[i].Token)
+		}
+	}
+	for i := range clauses.Group {
+		if err == nil {
+			err = qualify(&clauses.Group[i].Token)
+		}
+	}
+
+	return err
+}
+
+// qualifyName gives a bareword its table if it names a column of only one
+// of the tables without it.
+func qualifyName(query string, value *token, column_names []string) error {
+	if value.Type != bareword_token_type || strings_contains(value.Value, column_names) != -1 {
+		return nil
+	}
+
+	var found []string
+	for _, name := range column_names {
+		if name[strings.IndexByte(name, '.')+1:] == value.Value {
+			found = append(found, name)
+		}
+	}
+
+	if len(found) > 1 {
+		return &QueryError{Query: query, Offset: value.Offset, Reason: "column " + value.Value + " is in both tables; qualify it, as in " + found[0]}
+	} else if len(found) == 1 {
+		value.Value = found[0]
+	}
+	return nil
+}
+
+// joinKeys finds the equalities between the tables which every joined pair
+// must meet: those of a conjunction, but not inside OR or NOT.
+func joinKeys(root *queryNode, left_columns int, column_types []int) []joinKey {
+	if root.Kind == and_node_kind {
+		return append(joinKeys(root.Left, left_columns, column_types), joinKeys(root.Right, left_columns, column_types)...)
+	}
+
+	if root.Kind != relation_node_kind || (root.Token.Value != "=" && root.Token.Value != "==") || root.Left.Kind != column_node_kind || root.Right.Kind != column_node_kind {
+		return nil
+	}
+
+	var left, right int = root.Left.Column, root.Right.Column
+	if left > right {
+		left, right = right, left
+	}
+	if left >= left_columns || right < left_columns {
+		return nil
+	}
+
+	// Values are hashed as they are compared: see compileRelation.
+	var kind int = column_types[left]
+	if column_types[right] != kind {
+		if kind == 5 || kind == 6 || column_types[right] == 5 || column_types[right] == 6 {
+			kind = 6
+		} else {
+			kind = 2
+		}
+	}
+
+	return []joinKey{{left: left, right: right - left_columns, kind: kind, relation: root}}
+}
+
+// Columns returns the columns of the joined rows, named `table.column`.
+func (j *Join) Columns() []Column {
+	var result []Column = make([]Column, len(j.columns))
+	copy(result, j.columns)
+	return result
+}
+
+func (j *Join) Kind() JoinKind {
+	return j.kind
+}
+
+// Names returns the names which qualify the columns of the left and right
+// tables.
+func (j *Join) Names() (string, string) {
+	return j.names[0], j.names[1]
+}
+
+// Strategy describes how the join finds the pairs of rows which join.
+func (j *Join) Strategy() string {
+	if len(j.keys) == 0 {
+		return "nested-loop join"
+	}
+
+	var keys []string
+	for i := range j.keys {
+		keys = append(keys, prettyQuery(j.keys[i].relation))
+	}
+	return "hash join on " + strings.Join(keys, ", ")
+}
+
+// String returns the join as it will be evaluated.
+func (j *Join) String() string {
+	var result string = j.names[0] + " " + strings.ToUpper(j.kind.String()) + " JOIN " + j.names[1] + " ON " + prettyQuery(j.on)
+	if j.where.root != nil {
+		result += " WHERE " + j.where.String()
+	} else if where := j.where.String(); where != "" {
+		result += " " + where
+	}
+	return result
+}
+
+// SelectColumns looks up the named columns of the joined rows, which may
+// leave out their table, and returns their positions.
+func (j *Join) SelectColumns(names []string) ([]int, error) {
+	var qualified []string
+	for i := range names {
+		var value token = token{Value: names[i], Type: bareword_token_type}
+		err := qualifyName(names[i], &value, j.where.column_names)
+		if err != nil {
+			return nil, &SchemaError{Reason: err.(*QueryError).Reason}
+		}
+		qualified = append(qualified, value.Value)
+	}
+
+	return selectColumns(j.columns, qualified)
+}
+
+/**
+ * Rows returns the joined rows which match WHERE. The right table is read
+ * first; the left table stays locked, as by Search, until the returned Rows
+ * is closed. Row.RID is the row id of the left row.
+**/
+func (j *Join) Rows() (*Rows, error) {
+	var reader *joinReader = &joinReader{join: j}
+
+	err := reader.readRight()
+	if err != nil {
+		return nil, err
+	}
+
+	left, err := j.left.scan(nil)
+	if err != nil {
+		return nil, err
+	}
+	reader.left = left.records
+
+	var result *Rows = &Rows{table: j.left, lock: left.lock, file: left.file, records: reader, query: j.where}
+	return result, nil
+}
+
+/**
+ * A joinReader returns the joined rows as the records of a Rows, so that
+ * WHERE, ORDER BY, LIMIT and OFFSET are applied as for a search. With a hash
+ * join, buckets holds the right rows by their key. missing is set when the
+ * last row returned is a left row which joined no right row.
+**/
+type joinReader struct {
+	join    *Join
+	left    recordReader
+	right   []Row
+	every   []int
+	buckets map[string][]int
+	row_id  int
+	values  []string
+	tries   []int
+	matched bool
+	missing bool
+}
+
+// readRight reads every live row of the right table into memory.
+func (r *joinReader) readRight() error {
+	rows, err := r.join.right.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if len(r.join.keys) > 0 {
+		r.buckets = map[string][]int{}
+	}
+
+	for rows.Next() {
+		var row Row = rows.Row()
+		if r.buckets != nil {
+			key, err := r.key(row.Values, false)
+			if err != nil {
+				return &RowError{RID: row.RID, Reason: "in table " + r.join.names[1] + ": " + err.Error()}
+			}
+			r.buckets[key] = append(r.buckets[key], len(r.right))
+		}
+
+		r.every = append(r.every, len(r.right))
+		r.right = append(r.right, row)
+	}
+
+	return rows.Err()
+}
+
+// key returns the hash key of the values of a left or right row.
+func (r *joinReader) key(values []string, left bool) (string, error) {
+	var parts []string
+	for _, key := range r.join.keys {
+		var value string = values[key.right]
+		if left {
+			value = values[key.left]
+		}
+
+		var err error
+		switch key.kind {
+		case 1:
+			var integer int
+			integer, err = parseRowInteger(value)
+			value = strconv.Itoa(integer)
+		case 2:
+			var double float64
+			double, err = parseRowDouble(value)
+			if double == 0 {
+				// -0 equals 0.
+				double = 0
+			}
+			value = formatDouble(double)
+		case 3:
+			value, err = parseRowBoolean(value)
+		case 4:
+			// The same folding as the strings.EqualFold of the ON plan.
+			if r.join.ignore {
+				value = foldCase(value)
+			}
+		case 5, 6:
+			exact, err := parseRowExact(value)
+			if err != nil {
+				return "", err
+			}
+			value = exact.RatString()
+		}
+
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, value)
+	}
+
+	return formatRecord(0, parts), nil
+}
+
+func (r *joinReader) next() (int, bool, []string, error) {
+	for {
+		for len(r.tries) > 0 {
+			var right Row = r.right[r.tries[0]]
+			r.tries = r.tries[1:]
+
+			var values []string = append(append([]string(nil), r.values...), right.Values...)
+			matched, err := r.join.plan(values)
+			if err != nil {
+				return 0, false, nil, &RowError{RID: r.row_id, Reason: err.Error() + " (joining row " + strconv.Itoa(right.RID) + " of " + r.join.names[1] + ")"}
+			}
+
+			if matched {
+				r.matched, r.missing = true, false
+				return r.row_id, true, values, nil
+			}
+		}
+
+		if r.values != nil && !r.matched && r.join.kind == LeftJoin {
+			var values []string = append(append([]string(nil), r.values...), make([]string, len(r.join.columns)-len(r.values))...)
+			r.values, r.missing = nil, true
+			return r.row_id, true, values, nil
+		}
+
+		row_id, live, values, err := r.left.next()
+		if err != nil {
+			return 0, false, nil, err
+		} else if !live {
+			continue
+		}
+
+		r.row_id, r.values, r.matched = row_id, values, false
+		r.tries = r.every
+		if r.buckets != nil {
+			key, err := r.key(values, true)
+			if err != nil {
+				return 0, false, nil, &RowError{RID: row_id, Reason: err.Error()}
+			}
+			r.tries = r.buckets[key]
+		}
+	}
+}
+
+func (r *joinReader) location() int64 {
+	return r.left.location()
+}
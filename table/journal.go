@@ -0,0 +1,399 @@
+package table
+
+import (
+	"bufio"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Write-ahead journal. Before a mutation touches the table, it is described
+ * in <filename>.journal and synced to disk:
+ *
+ *      pet-journal 1
+ *      op <append|tombstone|delete|upgrade|update|replace|changes|rewrite|pages>
+ *      before <table header line before the mutation>
+ *      after <table header line after the mutation>
+ *      rid <row id>
+ *      offset <byte offset of the record line>
+ *      row <record line>
+ *      replaced <record line>
+ *      record <record line>
+ *      ...
+ *      tombstone <byte offset> <record line>
+ *      ...
+ *      page <page number> <hex page contents>
+ *      ...
+ *      end <crc32 of the preceding lines>
+ *
+ * For append and upgrade (an insert into a table older than the current
+ * version, which rewrites it) the row is the new record. For tombstone (a
+ * delete in place) and delete (a delete which rewrites an older table) it is
+ * the deleted record and rid is its row id. Only tombstone uses the offset;
+ * it is optional so that journals written before it existed still parse.
+ *
+ * For update (an update in place) and replace (an update which rewrites an
+ * older table) the row is the new record and replaced is the old one, which
+ * both carry rid as their row id. An update appends the new record and then
+ * tombstones the old one at offset. Other operations leave replaced empty,
+ * and it too is optional.
+ *
+ * A statement which changes several rows at once is a single mutation. In
+ * a current table it is op changes: each record line is appended, in order,
+ * and then each tombstone line names a record to mark as deleted, so that
+ * an update of a row is both. An older table is rewritten as op rewrite,
+ * whose record lines are every record of the new table. Both leave rid and
+ * offset zero and row empty, and only they have record or tombstone lines.
+ *
+ * Tables in the page format journal every change as op pages, with the new
+ * image of each page it touches and empty before, after and row lines.
+ * Writing the images is idempotent, so recovery always redoes it.
+ *
+ * Once the end line is on disk the mutation is committed. Recovery redoes it
+ * if the table still has the before header and does nothing if it already
+ * has the after header; either way the journal is then removed. A journal
+ * without a valid end line is rolled back by simply removing it, as the table
+ * is never touched before the journal is complete.
+**/
+var journalMagic string = "pet-journal 1"
+
+type journal struct {
+	op         string
+	before     string
+	after      string
+	rid        int
+	offset     int64
+	row        string
+	replaced   string
+	records    []string
+	tombstones []tombstone
+	pages      []pageImage
+}
+
+type tombstone struct {
+	offset int64
+	line   string
+}
+
+type pageImage struct {
+	number int
+	data   []byte
+}
+
+func (t *Table) journalName() string {
+	return t.filename + ".journal"
+}
+
+func formatJournal(j *journal) string {
+	var result string = journalMagic + "\n"
+	result += "op " + j.op + "\n"
+	result += "before " + j.before + "\n"
+	result += "after " + j.after + "\n"
+	result += "rid " + strconv.Itoa(j.rid) + "\n"
+	result += "offset " + strconv.FormatInt(j.offset, 10) + "\n"
+	result += "row " + j.row + "\n"
+	result += "replaced " + j.replaced + "\n"
+	for i := range j.records {
+		result += "record " + j.records[i] + "\n"
+	}
+	for i := range j.tombstones {
+		result += "tombstone " + strconv.FormatInt(j.tombstones[i].offset, 10) + " " + j.tombstones[i].line + "\n"
+	}
+	for i := range j.pages {
+		result += "page " + strconv.Itoa(j.pages[i].number) + " " + hex.EncodeToString(j.pages[i].data) + "\n"
+	}
+	result += "end " + strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(result))), 10) + "\n"
+	return result
+}
+
+// parseJournal returns nil if the journal is incomplete.
+func parseJournal(data string) *journal {
+	var end int = strings.LastIndex(data, "\nend ")
+	if end == -1 || !strings.HasPrefix(data, journalMagic+"\n") || !strings.HasSuffix(data, "\n") {
+		return nil
+	}
+
+	checksum, err := strconv.ParseUint(data[end+5:len(data)-1], 10, 32)
+	if err != nil || uint32(checksum) != crc32.ChecksumIEEE([]byte(data[:end+1])) {
+		return nil
+	}
+
+	var result *journal = &journal{}
+	var lines []string = strings.Split(data[len(journalMagic)+1:end], "\n")
+	for i := range lines {
+		var item []string = strings.SplitN(lines[i], " ", 2)
+		if len(item) != 2 {
+			return nil
+		}
+
+		switch item[0] {
+		case "op":
+			result.op = item[1]
+		case "before":
+			result.before = item[1]
+		case "after":
+			result.after = item[1]
+		case "rid":
+			result.rid, err = strconv.Atoi(item[1])
+			if err != nil {
+				return nil
+			}
+		case "offset":
+			result.offset, err = strconv.ParseInt(item[1], 10, 64)
+			if err != nil {
+				return nil
+			}
+		case "row":
+			result.row = item[1]
+		case "replaced":
+			result.replaced = item[1]
+		case "record":
+			result.records = append(result.records, item[1])
+		case "tombstone":
+			var record []string = strings.SplitN(item[1], " ", 2)
+			if len(record) != 2 {
+				return nil
+			}
+
+			var deleted tombstone = tombstone{line: record[1]}
+			deleted.offset, err = strconv.ParseInt(record[0], 10, 64)
+			if err != nil {
+				return nil
+			}
+
+			result.tombstones = append(result.tombstones, deleted)
+		case "page":
+			var page []string = strings.SplitN(item[1], " ", 2)
+			if len(page) != 2 {
+				return nil
+			}
+
+			var image pageImage
+			image.number, err = strconv.Atoi(page[0])
+			if err != nil {
+				return nil
+			}
+
+			image.data, err = hex.DecodeString(page[1])
+			if err != nil || len(image.data) != pageSize {
+				return nil
+			}
+
+			result.pages = append(result.pages, image)
+		default:
+			return nil
+		}
+	}
+
+	return result
+}
+
+func writeJournal(filename string, j *journal) error {
+	err := writeStep(StepJournal)
+	if err != nil {
+		return err
+	}
+
+	fw, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.Write([]byte(formatJournal(j)))
+
+	if err == nil {
+		err = writeStep(StepJournalSync)
+	}
+
+	if err == nil {
+		err = fw.Sync()
+	}
+
+	var close_err error = fw.Close()
+	if err == nil {
+		err = close_err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+func removeJournal(filename string) error {
+	err := writeStep(StepRemoveJournal)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+// readHeaderLine returns the first line of a table file exactly as stored.
+func readHeaderLine(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		return "", s.Err()
+	}
+
+	return s.Text(), nil
+}
+
+// commit journals a mutation, applies it to the table and removes the
+// journal. The caller must hold an exclusive lock. If applying fails after the
+// journal is on disk, it is retried once; failing that, the mutation is
+// completed the next time the table is opened.
+func (t *Table) commit(j *journal) error {
+	var journal_name string = t.journalName()
+
+	err := writeJournal(journal_name, j)
+	if err != nil {
+		os.Remove(journal_name)
+		return err
+	}
+
+	err = t.redo(j)
+	if err != nil {
+		err = t.redo(j)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = removeJournal(journal_name)
+	if err != nil {
+		return err
+	}
+
+	return t.refresh()
+}
+
+// recover completes or rolls back a mutation interrupted by a crash. The
+// caller must hold an exclusive lock.
+func (t *Table) recover() error {
+	var journal_name string = t.journalName()
+
+	data, err := ioutil.ReadFile(journal_name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var j *journal = parseJournal(string(data))
+	if j != nil {
+		err = t.redo(j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return removeJournal(journal_name)
+}
+
+// redo applies a journaled mutation unless the table already reflects it.
+func (t *Table) redo(j *journal) error {
+	if j.op == "pages" {
+		err := writePages(t.filename, j.pages)
+		if err != nil {
+			return &JournalError{Filename: t.journalName(), Reason: err.Error()}
+		}
+		return nil
+	}
+
+	current, err := readHeaderLine(t.filename)
+	if err != nil {
+		return err
+	}
+
+	if current == j.after {
+		return nil
+	}
+
+	before, err := parseHeader(j.before)
+	if err != nil {
+		return &JournalError{Filename: t.journalName(), Reason: "cannot parse header: " + err.Error()}
+	}
+
+	after, err := parseHeader(j.after)
+	if err != nil {
+		return &JournalError{Filename: t.journalName(), Reason: "cannot parse header: " + err.Error()}
+	}
+
+	// Appends, tombstones, updates and changes only update the header once
+	// the records are in place, so a header matching neither side is a torn
+	// update; all four are safe to redo from the start.
+	if j.op == "append" {
+		_, err = appendLine(t.filename, before, j.row)
+		return err
+	} else if j.op == "tombstone" {
+		err = tombstoneLine(t.filename, before, after, j.offset, j.row)
+		if err != nil {
+			return &JournalError{Filename: t.journalName(), Reason: err.Error()}
+		}
+		return nil
+	} else if j.op == "update" {
+		err = updateLine(t.filename, before, after, j.offset, j.replaced, j.row)
+		if err != nil {
+			return &JournalError{Filename: t.journalName(), Reason: err.Error()}
+		}
+		return nil
+	} else if j.op == "changes" {
+		err = changeLines(t.filename, before, after, j.records, j.tombstones)
+		if err != nil {
+			return &JournalError{Filename: t.journalName(), Reason: err.Error()}
+		}
+		return nil
+	}
+
+	if current != j.before {
+		return &JournalError{Filename: t.journalName(), Reason: "table header matches neither side of the journaled " + j.op}
+	}
+
+	lines, err := readLines(t.filename, before)
+	if err != nil {
+		return err
+	}
+
+	if j.op == "delete" {
+		// Older tables are re-encoded with their position as row id.
+		if j.rid < 0 || j.rid >= len(lines) || lines[j.rid] != j.row {
+			return &JournalError{Filename: t.journalName(), Reason: "journaled record " + strconv.Itoa(j.rid) + " is not in the table"}
+		}
+
+		var saved []string = lines[j.rid+1:]
+		lines = append(lines[0:j.rid], saved...)
+	} else if j.op == "replace" {
+		if j.rid < 0 || j.rid >= len(lines) || lines[j.rid] != j.replaced {
+			return &JournalError{Filename: t.journalName(), Reason: "journaled record " + strconv.Itoa(j.rid) + " is not in the table"}
+		}
+
+		lines[j.rid] = j.row
+	} else if j.op == "upgrade" {
+		lines = append(lines, j.row)
+	} else if j.op == "rewrite" {
+		lines = j.records
+	} else {
+		return &JournalError{Filename: t.journalName(), Reason: "unknown operation: " + j.op}
+	}
+
+	_, err = writeLines(t.filename, after, lines)
+	return err
+}
@@ -0,0 +1,122 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+/**
+ * Tables are protected by an advisory lock on a sidecar <filename>.lock
+ * file. The table file itself cannot be locked because rewrites rename a new
+ * file over it. Readers (Open, Rows, Row, Search) take a shared lock and
+ * writers (Create, Insert, Update, Delete, InsertRows, UpdateWhere,
+ * DeleteWhere, CreateIndex) take an exclusive one. The lock also covers the
+ * journal and the indexes of the table. A lock that cannot be obtained
+ * within the table's lock timeout fails with a LockError.
+**/
+var DefaultLockTimeout time.Duration = 10 * time.Second
+var lockPollInterval time.Duration = 10 * time.Millisecond
+
+var errLocked error = errors.New("lock held by another process")
+
+type lock struct {
+	file *os.File
+}
+
+func (t *Table) lock(exclusive bool) (*lock, error) {
+	var lock_name string = t.filename + ".lock"
+
+	f, err := os.OpenFile(lock_name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil && !exclusive && os.IsPermission(err) {
+		// Readers may not be able to create the lock file, for example
+		// in a read-only directory; locking an existing one is enough.
+		f, err = os.Open(lock_name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var deadline time.Time = time.Now().Add(t.lockTimeout)
+
+	for {
+		err = tryLock(f, exclusive)
+		if err == nil {
+			return &lock{file: f}, nil
+		}
+
+		if err != errLocked {
+			f.Close()
+			return nil, err
+		}
+
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, &LockError{Filename: t.filename, Exclusive: exclusive, Timeout: t.lockTimeout}
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// acquire locks the table, completes any mutation interrupted by a crash,
+// and re-reads the header. Recovery needs an exclusive lock, so a reader that
+// finds a journal briefly upgrades.
+func (t *Table) acquire(exclusive bool) (*lock, error) {
+	for {
+		l, err := t.lock(exclusive)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exclusive {
+			if _, err := os.Stat(t.journalName()); err == nil {
+				l.release()
+
+				l, err = t.lock(true)
+				if err != nil {
+					return nil, err
+				}
+
+				err = t.recover()
+				l.release()
+				if err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+		} else {
+			err = t.recover()
+			if err != nil {
+				l.release()
+				return nil, err
+			}
+		}
+
+		err = t.refresh()
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+
+		return l, nil
+	}
+}
+
+func (l *lock) release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	var err error = unlock(l.file)
+
+	var close_err error = l.file.Close()
+	if err == nil {
+		err = close_err
+	}
+
+	l.file = nil
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package table
+
+import (
+	"os"
+)
+
+// Advisory locking is only implemented with flock(2); elsewhere tables are
+// not protected against concurrent writers.
+
+func tryLock(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,362 @@
+package table
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+)
+
+/**
+ * Search sorts the rows of a query with ORDER BY by an external merge sort.
+ * Matching rows are gathered in memory until they take up about SortMemory
+ * bytes; they are then sorted and written to a temporary file as a run, in
+ * the record format of text tables, where a missing row of a left join (see
+ * Row) is written as a deleted record. Once every row has been read, the
+ * runs and the rows still in memory are merged. Rows which compare equal
+ * keep their file order. So that a sort never holds more than max_sort_runs
+ * files open, the runs are merged into one whenever there are that many.
+ *
+ * With a LIMIT, only the first OFFSET + LIMIT rows in order can ever be
+ * returned, so the rest are dropped whenever the rows in memory are sorted;
+ * a search for the top few rows therefore never spills to disk.
+**/
+
+// SortMemory is roughly how many bytes of rows a sorted search keeps in
+// memory before spilling them to a temporary file.
+var SortMemory int64 = 64 << 20
+
+const max_sort_runs int = 64
+
+// A sortedRow is a row along with the parsed values of its sort keys.
+type sortedRow struct {
+	row  Row
+	keys []interface{}
+}
+
+// A sortRun is one sorted run of rows, either in a temporary file or, for
+// the last one, still in memory.
+type sortRun struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	rows    []sortedRow
+	current sortedRow
+	done    bool
+}
+
+type rowSorter struct {
+	order     []orderKey
+	types     []int
+	collation Collation
+	columns   int
+	keep      int
+	rows      []sortedRow
+	size      int64
+	runs      []*sortRun
+	missing   int
+}
+
+// newRowSorter sorts rows of the given column types by the ORDER BY of the
+// clauses: the rows of a table, or those of groups (see aggregate.go).
+func newRowSorter(clauses queryClauses, types []int, collation Collation) *rowSorter {
+	var result *rowSorter = &rowSorter{order: clauses.Order, types: types, collation: collation, columns: len(types), keep: -1}
+	if clauses.Limit != -1 {
+		result.keep = clauses.Offset + clauses.Limit
+	}
+	return result
+}
+
+// add takes a matched row, spilling the rows in memory to a run if they
+// have grown too large.
+func (s *rowSorter) add(row Row) error {
+	keys, err := s.parseKeys(row)
+	if err != nil {
+		return &RowError{RID: row.RID, Reason: err.Error()}
+	}
+
+	s.rows = append(s.rows, sortedRow{row: row, keys: keys})
+	s.size += rowSize(row.Values)
+	if s.size <= SortMemory {
+		return nil
+	}
+
+	s.sortMemory()
+	if s.size <= SortMemory/2 {
+		return nil
+	}
+
+	return s.spill()
+}
+
+// finish sorts the rows in memory and starts merging them with the runs.
+func (s *rowSorter) finish() error {
+	s.sortMemory()
+	s.runs = append(s.runs, &sortRun{rows: s.rows})
+	s.rows = nil
+
+	for _, run := range s.runs {
+		err := s.advance(run)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// next returns the next row in order, or false after the last one.
+func (s *rowSorter) next() (Row, bool, error) {
+	var smallest *sortRun
+	for _, run := range s.runs {
+		if run.done {
+			continue
+		}
+
+		// Earlier runs hold earlier rows, so they win ties.
+		if smallest == nil || s.compare(run.current, smallest.current) < 0 {
+			smallest = run
+		}
+	}
+
+	if smallest == nil {
+		return Row{}, false, nil
+	}
+
+	var result Row = smallest.current.row
+	return result, true, s.advance(smallest)
+}
+
+// close removes the temporary files of the runs.
+func (s *rowSorter) close() {
+	for _, run := range s.runs {
+		if run.file != nil {
+			run.file.Close()
+			os.Remove(run.file.Name())
+		}
+	}
+	s.runs = nil
+}
+
+// sortMemory sorts the rows in memory and drops those past the limit.
+func (s *rowSorter) sortMemory() {
+	sort.SliceStable(s.rows, func(i int, j int) bool { return s.compare(s.rows[i], s.rows[j]) < 0 })
+
+	if s.keep != -1 && len(s.rows) > s.keep {
+		for i := s.keep; i < len(s.rows); i++ {
+			s.rows[i] = sortedRow{}
+		}
+		s.rows = s.rows[:s.keep]
+
+		s.size = 0
+		for i := range s.rows {
+			s.size += rowSize(s.rows[i].row.Values)
+		}
+	}
+}
+
+// spill writes the sorted rows in memory to a new run.
+func (s *rowSorter) spill() error {
+	f, err := ioutil.TempFile("", "pet-sort")
+	if err != nil {
+		return err
+	}
+
+	var run *sortRun = &sortRun{file: f}
+	s.runs = append(s.runs, run)
+
+	var writer *bufio.Writer = bufio.NewWriter(f)
+	for i := range s.rows {
+		_, err = writer.WriteString(formatSortedRow(s.rows[i].row) + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rewindRun(run, writer)
+	if err != nil {
+		return err
+	}
+
+	s.rows = nil
+	s.size = 0
+
+	if len(s.runs) >= max_sort_runs {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact merges every run into a single new one.
+func (s *rowSorter) compact() error {
+	for _, run := range s.runs {
+		err := s.advance(run)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := ioutil.TempFile("", "pet-sort")
+	if err != nil {
+		return err
+	}
+
+	var merged *sortRun = &sortRun{file: f}
+	var writer *bufio.Writer = bufio.NewWriter(f)
+	for count := 0; s.keep == -1 || count < s.keep; count++ {
+		row, ok, err := s.next()
+		if err == nil && ok {
+			_, err = writer.WriteString(formatSortedRow(row) + "\n")
+		}
+
+		if err != nil {
+			s.runs = append(s.runs, merged)
+			return err
+		} else if !ok {
+			break
+		}
+	}
+
+	s.close()
+	s.runs = []*sortRun{merged}
+	return rewindRun(merged, writer)
+}
+
+// rewindRun flushes a run written to its file and starts reading it back.
+func rewindRun(run *sortRun, writer *bufio.Writer) error {
+	err := writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = run.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	run.scanner = bufio.NewScanner(run.file)
+	return nil
+}
+
+// advance moves a run on to its next row.
+func (s *rowSorter) advance(run *sortRun) error {
+	if run.scanner == nil {
+		if len(run.rows) == 0 {
+			run.done = true
+			return nil
+		}
+
+		run.current = run.rows[0]
+		run.rows[0] = sortedRow{}
+		run.rows = run.rows[1:]
+		return nil
+	}
+
+	if !run.scanner.Scan() {
+		run.done = true
+		return run.scanner.Err()
+	}
+
+	row_id, live, values, err := parseRecord(run.scanner.Text(), s.columns, currentVersion)
+	if err != nil {
+		return err
+	}
+
+	var row Row = Row{RID: row_id, Values: values, Missing: !live}
+	keys, err := s.parseKeys(row)
+	if err != nil {
+		return &RowError{RID: row_id, Reason: err.Error()}
+	}
+
+	run.current = sortedRow{row: row, keys: keys}
+	return nil
+}
+
+// formatSortedRow writes a row to a run, marking a missing row of a left
+// join as deleted.
+func formatSortedRow(row Row) string {
+	var line string = formatRecord(row.RID, row.Values)
+	if row.Missing {
+		line = "-" + line[1:]
+	}
+	return line
+}
+
+// parseKeys parses the values of a row which it is sorted by.
+func (s *rowSorter) parseKeys(row Row) ([]interface{}, error) {
+	var result []interface{} = make([]interface{}, len(s.order))
+	for i := range s.order {
+		var value string = row.Values[s.order[i].Column]
+		var err error
+
+		// The right table of a missing row of a left join has no keys.
+		if row.Missing && s.order[i].Column >= s.missing {
+			continue
+		}
+
+		switch s.types[s.order[i].Column] {
+		case 1:
+			result[i], err = parseRowInteger(value)
+		case 2:
+			result[i], err = parseRowDouble(value)
+		case 3:
+			result[i], err = parseRowBoolean(value)
+		case 5, 6:
+			result[i], err = parseRowExact(value)
+		default:
+			result[i] = value
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// compare orders two rows by their keys, returning -1, 0 or 1.
+func (s *rowSorter) compare(a sortedRow, b sortedRow) int {
+	for i := range s.order {
+		var comparison int
+		if a.keys[i] == nil || b.keys[i] == nil {
+			// Missing values come first.
+			if a.keys[i] != nil {
+				comparison = 1
+			} else if b.keys[i] != nil {
+				comparison = -1
+			}
+		} else {
+			switch left := a.keys[i].(type) {
+			case int:
+				comparison = compareIntegers(left, b.keys[i].(int))
+			case float64:
+				comparison = compareDoubles(left, b.keys[i].(float64))
+			case *big.Rat:
+				comparison = left.Cmp(b.keys[i].(*big.Rat))
+			case string:
+				// Booleans are T or F, so every collation puts F first.
+				comparison = s.collation.Compare(left, b.keys[i].(string))
+			}
+		}
+
+		if s.order[i].Descending {
+			comparison = -comparison
+		}
+		if comparison != 0 {
+			return comparison
+		}
+	}
+
+	return 0
+}
+
+// rowSize estimates the memory taken by the values of a row.
+func rowSize(values []string) int64 {
+	var result int64 = 64
+	for i := range values {
+		result += int64(16 + len(values[i]))
+	}
+	return result
+}
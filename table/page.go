@@ -0,0 +1,694 @@
+package table
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+/**
+ * On-disk page format. The file is a sequence of fixed-size pages; all
+ * integers are big-endian.
+ *
+ * Page 0 is the header:
+ *      magic       8 bytes, "PETPAGES"
+ *      version     u32
+ *      page size   u32
+ *      pages       u32, including the header page
+ *      records     u64, live records only
+ *      next rid    u64
+ *      changes     u64, the number of inserts, updates and deletes so far
+ *      columns     u16
+ *      for each column:
+ *          type    u8
+ *          length  u16
+ *          name    <length> bytes
+ *          and for a decimal only:
+ *          precision   u16
+ *          scale       u16
+ *
+ * Every other page is a slotted data page:
+ *      slots       u16
+ *      free end    u16, offset of the lowest record in the page
+ *      for each slot:
+ *          offset  u16
+ *          length  u16
+ *
+ * Slots grow from the front of the page and records are packed from the
+ * back. A record is a status byte (1 live, 0 deleted), its u64 row id, and
+ * then each value in column order:
+ *      Integer:    int64, 8 bytes
+ *      Double:     float64, 8 bytes
+ *      Boolean:    1 byte, 1 for T and 0 for F
+ *      String:     u32 length followed by the bytes
+ *      BigInteger and Decimal: as a string, in the form ParseValue gives
+ *
+ * Inserts add a record to the last page, or start a new page when it is
+ * full; deletes clear the status byte of their record. Updates clear the
+ * status byte of the old record and add the new one, with the same row id,
+ * to its page when there is room and otherwise as an insert would. All are
+ * journaled as whole page images (see journal.go) and then written in place.
+ *
+ * An update in place leaves the counts and the size of the table as they
+ * were, so only the change count tells an index that it missed one. Version
+ * 1 headers have no change count; they are read as no changes so far and
+ * written as version 2 by the next change.
+**/
+type Format int
+
+const (
+	TextFormat Format = iota
+	PageFormat
+)
+
+var formatNames map[Format]string = map[Format]string{TextFormat: "text", PageFormat: "page"}
+
+func (f Format) String() string {
+	name, ok := formatNames[f]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+// ParseFormat returns the format with the given name, as accepted by create.
+func ParseFormat(name string) (Format, error) {
+	for format := range formatNames {
+		if formatNames[format] == name {
+			return format, nil
+		}
+	}
+
+	return TextFormat, errors.New("unknown table format `" + name + "`: expected text or page")
+}
+
+var pageMagic []byte = []byte("PETPAGES")
+var pageVersion int = 2
+var pageSize int = 4096
+
+var pageHeaderSize int = 4
+var slotSize int = 4
+var recordHeaderSize int = 9
+
+// isPageFile reports whether the file starts with the page format magic.
+func isPageFile(f *os.File) (bool, error) {
+	var magic []byte = make([]byte, len(pageMagic))
+	_, err := f.ReadAt(magic, 0)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(magic, pageMagic), nil
+}
+
+func encodePageHeader(h header) ([]byte, error) {
+	var result []byte = make([]byte, pageSize)
+	copy(result, pageMagic)
+	binary.BigEndian.PutUint32(result[8:], uint32(pageVersion))
+	binary.BigEndian.PutUint32(result[12:], uint32(pageSize))
+	binary.BigEndian.PutUint32(result[16:], uint32(h.pages))
+	binary.BigEndian.PutUint64(result[20:], uint64(h.records))
+	binary.BigEndian.PutUint64(result[28:], uint64(h.next_rid))
+	binary.BigEndian.PutUint64(result[36:], uint64(h.changes))
+	binary.BigEndian.PutUint16(result[44:], uint16(len(h.columns)))
+
+	var offset int = 46
+	for i := range h.columns {
+		var name string = h.columns[i].Name
+		var length int = 3 + len(name)
+		if h.columns[i].Type == Decimal {
+			length += 4
+		}
+
+		if offset+length > pageSize {
+			return nil, &SchemaError{Reason: "attributes do not fit in the header page of the page format"}
+		}
+
+		result[offset] = byte(h.columns[i].Type)
+		binary.BigEndian.PutUint16(result[offset+1:], uint16(len(name)))
+		copy(result[offset+3:], name)
+		if h.columns[i].Type == Decimal {
+			binary.BigEndian.PutUint16(result[offset+3+len(name):], uint16(h.columns[i].Precision))
+			binary.BigEndian.PutUint16(result[offset+5+len(name):], uint16(h.columns[i].Scale))
+		}
+		offset += length
+	}
+
+	return result, nil
+}
+
+func decodePageHeader(page []byte) (header, error) {
+	var result header = header{format: PageFormat}
+
+	if len(page) < 46 || !bytes.Equal(page[0:8], pageMagic) {
+		return result, errors.New("missing page format magic")
+	}
+
+	result.version = int(binary.BigEndian.Uint32(page[8:]))
+	if result.version < 1 || result.version > pageVersion {
+		return result, errors.New("unknown page format version: " + strconv.Itoa(result.version))
+	}
+
+	var size int = int(binary.BigEndian.Uint32(page[12:]))
+	if size != pageSize {
+		return result, errors.New("unsupported page size: " + strconv.Itoa(size))
+	}
+
+	result.pages = int(binary.BigEndian.Uint32(page[16:]))
+	result.records = int(binary.BigEndian.Uint64(page[20:]))
+	result.next_rid = int(binary.BigEndian.Uint64(page[28:]))
+	result.size = int64(result.pages) * int64(pageSize)
+
+	if result.pages < 1 {
+		return result, errors.New("page count must include the header page")
+	}
+
+	var offset int = 36
+	if result.version >= 2 {
+		result.changes = int(binary.BigEndian.Uint64(page[36:]))
+		offset = 44
+	}
+
+	var columns int = int(binary.BigEndian.Uint16(page[offset:]))
+	offset += 2
+	for i := 0; i < columns; i++ {
+		if offset+3 > len(page) {
+			return result, errors.New("truncated column " + strconv.Itoa(i+1))
+		}
+
+		var column_type ColumnType = ColumnType(page[offset])
+		var length int = int(binary.BigEndian.Uint16(page[offset+1:]))
+		if !column_type.Valid() || offset+3+length > len(page) {
+			return result, errors.New("invalid column " + strconv.Itoa(i+1))
+		}
+
+		var column Column = Column{Name: string(page[offset+3 : offset+3+length]), Type: column_type}
+		offset += 3 + length
+
+		if column_type == Decimal {
+			if offset+4 > len(page) {
+				return result, errors.New("truncated column " + strconv.Itoa(i+1))
+			}
+
+			column.Precision = int(binary.BigEndian.Uint16(page[offset:]))
+			column.Scale = int(binary.BigEndian.Uint16(page[offset+2:]))
+			offset += 4
+		}
+
+		if ValidateColumnType(column) != nil {
+			return result, errors.New("invalid column " + strconv.Itoa(i+1))
+		}
+
+		result.columns = append(result.columns, column)
+	}
+
+	return result, nil
+}
+
+// readPage reads page number n of a page format table.
+func readPage(f *os.File, n int) ([]byte, error) {
+	var result []byte = make([]byte, pageSize)
+	_, err := f.ReadAt(result, int64(n)*int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func newDataPage() []byte {
+	var result []byte = make([]byte, pageSize)
+	binary.BigEndian.PutUint16(result[2:], uint16(pageSize))
+	return result
+}
+
+func pageSlots(page []byte) int {
+	return int(binary.BigEndian.Uint16(page[0:]))
+}
+
+// pageRecord returns the bytes of the record in the given slot.
+func pageRecord(page []byte, slot int) ([]byte, error) {
+	var start int = pageHeaderSize + slot*slotSize
+	if start+slotSize > len(page) {
+		return nil, errors.New("slot " + strconv.Itoa(slot) + " lies outside the page")
+	}
+
+	var offset int = int(binary.BigEndian.Uint16(page[start:]))
+	var length int = int(binary.BigEndian.Uint16(page[start+2:]))
+	if offset+length > len(page) || length < recordHeaderSize {
+		return nil, errors.New("slot " + strconv.Itoa(slot) + " points outside the page")
+	}
+
+	return page[offset : offset+length], nil
+}
+
+// addRecord places a record in a data page, returning false if it is full.
+func addRecord(page []byte, record []byte) bool {
+	var slots int = pageSlots(page)
+	var free_end int = int(binary.BigEndian.Uint16(page[2:]))
+	if free_end == 0 {
+		// An all-zero page was never initialised by newDataPage.
+		free_end = pageSize
+	}
+
+	var slots_end int = pageHeaderSize + (slots+1)*slotSize
+	if free_end-len(record) < slots_end {
+		return false
+	}
+
+	free_end -= len(record)
+	copy(page[free_end:], record)
+
+	var start int = pageHeaderSize + slots*slotSize
+	binary.BigEndian.PutUint16(page[start:], uint16(free_end))
+	binary.BigEndian.PutUint16(page[start+2:], uint16(len(record)))
+	binary.BigEndian.PutUint16(page[0:], uint16(slots+1))
+	binary.BigEndian.PutUint16(page[2:], uint16(free_end))
+	return true
+}
+
+// encodeRecord encodes values, as returned by ParseValue, for a data page.
+func encodeRecord(columns []Column, row_id int, values []string) ([]byte, error) {
+	var result []byte = make([]byte, recordHeaderSize)
+	result[0] = 1
+	binary.BigEndian.PutUint64(result[1:], uint64(row_id))
+
+	var buffer [8]byte
+	for i := range columns {
+		switch columns[i].Type {
+		case Integer:
+			value, err := strconv.ParseInt(values[i], 10, 64)
+			if err != nil {
+				return nil, &ValueError{Column: columns[i], Value: values[i], Reason: "unable to convert input to integer: " + err.Error()}
+			}
+			binary.BigEndian.PutUint64(buffer[:], uint64(value))
+			result = append(result, buffer[:]...)
+		case Double:
+			value, err := strconv.ParseFloat(values[i], 64)
+			if err != nil {
+				return nil, &ValueError{Column: columns[i], Value: values[i], Reason: "unable to convert input to double: " + err.Error()}
+			}
+			binary.BigEndian.PutUint64(buffer[:], math.Float64bits(value))
+			result = append(result, buffer[:]...)
+		case Boolean:
+			if values[i] == "T" {
+				result = append(result, 1)
+			} else {
+				result = append(result, 0)
+			}
+		default:
+			binary.BigEndian.PutUint32(buffer[:], uint32(len(values[i])))
+			result = append(result, buffer[:4]...)
+			result = append(result, values[i]...)
+		}
+	}
+
+	var largest int = pageSize - pageHeaderSize - slotSize
+	if len(result) > largest {
+		return nil, &SchemaError{Reason: "record of " + strconv.Itoa(len(result)) + " bytes does not fit in a page of the page format (at most " + strconv.Itoa(largest) + ")"}
+	}
+
+	return result, nil
+}
+
+func decodeRecord(columns []Column, record []byte) (int, bool, []string, error) {
+	var truncated error = errors.New("truncated record")
+
+	if len(record) < recordHeaderSize {
+		return -1, false, nil, truncated
+	}
+
+	var live bool = record[0] == 1
+	var row_id int = int(binary.BigEndian.Uint64(record[1:]))
+	var data []byte = record[recordHeaderSize:]
+
+	var result []string = make([]string, len(columns))
+	for i := range columns {
+		switch columns[i].Type {
+		case Integer:
+			if len(data) < 8 {
+				return -1, false, nil, truncated
+			}
+			result[i] = strconv.FormatInt(int64(binary.BigEndian.Uint64(data)), 10)
+			data = data[8:]
+		case Double:
+			if len(data) < 8 {
+				return -1, false, nil, truncated
+			}
+			result[i] = formatDouble(math.Float64frombits(binary.BigEndian.Uint64(data)))
+			data = data[8:]
+		case Boolean:
+			if len(data) < 1 {
+				return -1, false, nil, truncated
+			}
+			result[i] = "F"
+			if data[0] == 1 {
+				result[i] = "T"
+			}
+			data = data[1:]
+		default:
+			if len(data) < 4 {
+				return -1, false, nil, truncated
+			}
+			var length int = int(binary.BigEndian.Uint32(data))
+			if len(data) < 4+length {
+				return -1, false, nil, truncated
+			}
+			result[i] = string(data[4 : 4+length])
+			data = data[4+length:]
+		}
+	}
+
+	return row_id, live, result, nil
+}
+
+// formatDouble prints a double the way it would usually be typed, falling
+// back to an exponent only for very large or small values.
+func formatDouble(value float64) string {
+	var magnitude float64 = math.Abs(value)
+	if magnitude == 0 || (magnitude >= 1e-4 && magnitude < 1e21) {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type pageReader struct {
+	file     *os.File
+	h        header
+	page     []byte
+	number   int
+	slot     int
+	previous int64
+}
+
+func newPageReader(f *os.File, h header) *pageReader {
+	var result *pageReader = &pageReader{file: f, h: h, number: 0}
+	return result
+}
+
+func (r *pageReader) next() (int, bool, []string, error) {
+	for r.page == nil || r.slot >= pageSlots(r.page) {
+		r.number += 1
+		r.slot = 0
+		if r.number >= r.h.pages {
+			return -1, false, nil, io.EOF
+		}
+
+		page, err := readPage(r.file, r.number)
+		if err != nil {
+			return -1, false, nil, err
+		}
+		r.page = page
+	}
+
+	record, err := pageRecord(r.page, r.slot)
+	if err == nil {
+		var row_id int
+		var live bool
+		var values []string
+		row_id, live, values, err = decodeRecord(r.h.columns, record)
+		if err == nil {
+			r.previous = slotLocation(r.number, r.slot)
+			r.slot += 1
+			return row_id, live, values, nil
+		}
+	}
+
+	return -1, false, nil, &FormatError{Filename: r.file.Name(), Line: 0, Reason: "page " + strconv.Itoa(r.number) + ", slot " + strconv.Itoa(r.slot) + ": " + err.Error()}
+}
+
+func (r *pageReader) location() int64 {
+	return r.previous
+}
+
+// slotLocation returns the file offset of a slot, which identifies its
+// record for as long as the table exists.
+func slotLocation(number int, slot int) int64 {
+	return int64(number)*int64(pageSize) + int64(pageHeaderSize+slot*slotSize)
+}
+
+func locationSlot(location int64) (int, int) {
+	return int(location / int64(pageSize)), (int(location%int64(pageSize)) - pageHeaderSize) / slotSize
+}
+
+// createPages writes the header page of a new, empty page format table.
+func createPages(f *os.File, h header) (header, error) {
+	h.format = PageFormat
+	h.version = pageVersion
+	h.pages = 1
+	h.size = int64(pageSize)
+
+	page, err := encodePageHeader(h)
+	if err != nil {
+		return h, err
+	}
+
+	_, err = f.Write(page)
+	return h, err
+}
+
+// insertPages journals and applies an insert into a page format table,
+// returning the location of the new record.
+func (t *Table) insertPages(values []string) (int64, error) {
+	record, err := encodeRecord(t.columns, t.next_rid, values)
+	if err != nil {
+		return -1, err
+	}
+
+	f, err := os.Open(t.filename)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	var after header = t.header
+	after.records += 1
+	after.next_rid += 1
+	after.changes += 1
+
+	var number int = t.pages - 1
+	var page []byte
+	if number > 0 {
+		page, err = readPage(f, number)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	if page == nil || !addRecord(page, record) {
+		number = t.pages
+		page = newDataPage()
+		addRecord(page, record)
+		after.pages += 1
+		after.size += int64(pageSize)
+	}
+
+	header_page, err := encodePageHeader(after)
+	if err != nil {
+		return -1, err
+	}
+
+	var location int64 = slotLocation(number, pageSlots(page)-1)
+	return location, t.commit(&journal{op: "pages", rid: t.next_rid, pages: []pageImage{{number: number, data: page}, {number: 0, data: header_page}}})
+}
+
+// deletePages journals and applies a delete from a page format table,
+// returning the location and values of the deleted record.
+func (t *Table) deletePages(row_id int) (int64, []string, error) {
+	f, err := os.Open(t.filename)
+	if err != nil {
+		return -1, nil, err
+	}
+	defer f.Close()
+
+	for number := 1; number < t.pages; number++ {
+		page, err := readPage(f, number)
+		if err != nil {
+			return -1, nil, err
+		}
+
+		for slot := 0; slot < pageSlots(page); slot++ {
+			record, err := pageRecord(page, slot)
+			if err != nil {
+				return -1, nil, &FormatError{Filename: t.filename, Line: 0, Reason: "page " + strconv.Itoa(number) + ", slot " + strconv.Itoa(slot) + ": " + err.Error()}
+			}
+
+			if record[0] != 1 || int(binary.BigEndian.Uint64(record[1:])) != row_id {
+				continue
+			}
+
+			_, _, values, err := decodeRecord(t.columns, record)
+			if err != nil {
+				return -1, nil, &FormatError{Filename: t.filename, Line: 0, Reason: "page " + strconv.Itoa(number) + ", slot " + strconv.Itoa(slot) + ": " + err.Error()}
+			}
+
+			// record aliases page, so this clears the status in the image.
+			record[0] = 0
+
+			var after header = t.header
+			after.records -= 1
+			after.changes += 1
+
+			header_page, err := encodePageHeader(after)
+			if err != nil {
+				return -1, nil, err
+			}
+
+			return slotLocation(number, slot), values, t.commit(&journal{op: "pages", rid: row_id, pages: []pageImage{{number: number, data: page}, {number: 0, data: header_page}}})
+		}
+	}
+
+	return -1, nil, &NotFoundError{RID: row_id}
+}
+
+// updatePages journals and applies an update in a page format table. The
+// old record is deleted and the new one, under the same row id, is placed in
+// its page if it fits there and otherwise as an insert would place it. It
+// returns the location and values of the old record and the location of the
+// new one.
+func (t *Table) updatePages(row_id int, values []string) (int64, []string, int64, error) {
+	record, err := encodeRecord(t.columns, row_id, values)
+	if err != nil {
+		return -1, nil, -1, err
+	}
+
+	f, err := os.Open(t.filename)
+	if err != nil {
+		return -1, nil, -1, err
+	}
+	defer f.Close()
+
+	for number := 1; number < t.pages; number++ {
+		page, err := readPage(f, number)
+		if err != nil {
+			return -1, nil, -1, err
+		}
+
+		for slot := 0; slot < pageSlots(page); slot++ {
+			old_record, err := pageRecord(page, slot)
+			if err != nil {
+				return -1, nil, -1, &FormatError{Filename: t.filename, Line: 0, Reason: "page " + strconv.Itoa(number) + ", slot " + strconv.Itoa(slot) + ": " + err.Error()}
+			}
+
+			if old_record[0] != 1 || int(binary.BigEndian.Uint64(old_record[1:])) != row_id {
+				continue
+			}
+
+			_, _, old_values, err := decodeRecord(t.columns, old_record)
+			if err != nil {
+				return -1, nil, -1, &FormatError{Filename: t.filename, Line: 0, Reason: "page " + strconv.Itoa(number) + ", slot " + strconv.Itoa(slot) + ": " + err.Error()}
+			}
+
+			// old_record aliases page, so this clears the status in the image.
+			old_record[0] = 0
+
+			var after header = t.header
+			after.changes += 1
+			var pages []pageImage = []pageImage{{number: number, data: page}}
+			var new_number int = number
+			var new_page []byte = page
+
+			if !addRecord(page, record) {
+				new_number = t.pages - 1
+				if new_number != number {
+					new_page, err = readPage(f, new_number)
+					if err != nil {
+						return -1, nil, -1, err
+					}
+				}
+
+				if new_number == number || !addRecord(new_page, record) {
+					new_number = t.pages
+					new_page = newDataPage()
+					addRecord(new_page, record)
+					after.pages += 1
+					after.size += int64(pageSize)
+				}
+
+				pages = append(pages, pageImage{number: new_number, data: new_page})
+			}
+
+			header_page, err := encodePageHeader(after)
+			if err != nil {
+				return -1, nil, -1, err
+			}
+			pages = append(pages, pageImage{number: 0, data: header_page})
+
+			var location int64 = slotLocation(new_number, pageSlots(new_page)-1)
+			return slotLocation(number, slot), old_values, location, t.commit(&journal{op: "pages", rid: row_id, pages: pages})
+		}
+	}
+
+	return -1, nil, -1, &NotFoundError{RID: row_id}
+}
+
+// writePages writes journaled page images in place, data pages before the
+// header page, and drops anything past the pages the new header counts.
+// Writing the same images again is harmless, so recovery simply repeats it.
+func writePages(filename string, pages []pageImage) error {
+	var size int64 = -1
+	for i := range pages {
+		if pages[i].number == 0 {
+			h, err := decodePageHeader(pages[i].data)
+			if err != nil {
+				return err
+			}
+			size = h.size
+		}
+	}
+
+	if size == -1 {
+		return errors.New("journaled pages do not include the header page")
+	}
+
+	fw, err := os.OpenFile(filename, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	for _, header_page := range []bool{false, true} {
+		for i := range pages {
+			if (pages[i].number == 0) != header_page {
+				continue
+			}
+
+			err = writeStep(StepWrite)
+			if err != nil {
+				return err
+			}
+
+			_, err = fw.WriteAt(pages[i].data, int64(pages[i].number)*int64(pageSize))
+			if err != nil {
+				return err
+			}
+		}
+
+		if !header_page {
+			err = fw.Truncate(size)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = writeStep(StepSync)
+		if err != nil {
+			return err
+		}
+
+		err = fw.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
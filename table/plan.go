@@ -0,0 +1,601 @@
+package table
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/**
+ * A queryPlan decides whether one row matches a query. compileQuery builds
+ * it once per query from the checked tree: columns are already resolved to
+ * their positions, constants are parsed, IN lists become sets and every
+ * node becomes a closure, so that matching a row only parses the values of
+ * the row which the query reads. And and or stop as soon as their result is
+ * known.
+**/
+type queryPlan func(row []string) (bool, error)
+
+// A rowComparison compares a value of a row with a constant or with another
+// value of the same row, returning -1, 0 or 1.
+type rowComparison func(row []string) (int, error)
+
+// A sumPlan computes an arithmetic expression for a row.
+type sumPlan func(row []string) (queryNumber, error)
+
+func compileQuery(root *queryNode, column_types []int, options QueryOptions) (queryPlan, error) {
+	if root == nil {
+		// A query without a condition matches every row.
+		return func(row []string) (bool, error) {
+			return true, nil
+		}, nil
+	} else if root.Kind == and_node_kind || root.Kind == or_node_kind {
+		left, err := compileQuery(root.Left, column_types, options)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := compileQuery(root.Right, column_types, options)
+		if err != nil {
+			return nil, err
+		}
+
+		// And stops at the first false side, or at the first true one.
+		var stop bool = root.Kind == or_node_kind
+		return func(row []string) (bool, error) {
+			result, err := left(row)
+			if err != nil || result == stop {
+				return result, err
+			}
+			return right(row)
+		}, nil
+	} else if root.Kind == not_node_kind {
+		inner, err := compileQuery(root.Left, column_types, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(row []string) (bool, error) {
+			result, err := inner(row)
+			return !result && err == nil, err
+		}, nil
+	} else if root.Kind == relation_node_kind {
+		return compileRelation(root, column_types, options)
+	} else if root.Kind == in_node_kind {
+		return compileIn(root, column_types[root.Left.Column], options)
+	} else if root.Kind == between_node_kind {
+		var column int = root.Left.Column
+
+		low, err := compileComparison(column, column_types[column], root.Values[0], options, true)
+		if err != nil {
+			return nil, err
+		}
+
+		high, err := compileComparison(column, column_types[column], root.Values[1], options, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(row []string) (bool, error) {
+			comparison, err := low(row)
+			if err != nil || comparison < 0 {
+				return false, err
+			}
+
+			comparison, err = high(row)
+			return err == nil && comparison <= 0, err
+		}, nil
+	}
+
+	return nil, errors.New("Cannot evaluate `" + prettyQuery(root) + "` as a condition")
+}
+
+func compileRelation(relation *queryNode, column_types []int, options QueryOptions) (queryPlan, error) {
+	if relation.Pattern != nil {
+		var pattern *regexp.Regexp = relation.Pattern
+		var column int = relation.Left.Column
+		return func(row []string) (bool, error) {
+			return pattern.MatchString(row[column]), nil
+		}, nil
+	}
+
+	test, err := compileOperator(relation.Token.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var comparison rowComparison
+	if isArithmetic(relation.Left) || isArithmetic(relation.Right) {
+		left, err := compileSum(relation.Left, column_types)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := compileSum(relation.Right, column_types)
+		if err != nil {
+			return nil, err
+		}
+
+		comparison = func(row []string) (int, error) {
+			left_value, err := left(row)
+			if err != nil {
+				return 0, err
+			}
+
+			right_value, err := right(row)
+			if err != nil {
+				return 0, err
+			}
+
+			return compareNumbers(left_value, right_value), nil
+		}
+	} else {
+		var column int = relation.Left.Column
+		var comparison_type int = column_types[column]
+
+		// An integer compared with a double column is compared as a double,
+		// and any number compared with a bigint or decimal column exactly.
+		if relation.Right.Kind == column_node_kind && column_types[relation.Right.Column] != comparison_type {
+			var right_type int = column_types[relation.Right.Column]
+			if comparison_type == 5 || comparison_type == 6 || right_type == 5 || right_type == 6 {
+				comparison_type = 6
+			} else {
+				comparison_type = 2
+			}
+		}
+
+		var ordered bool = strings_contains(relation.Token.Value, []string{"=", "==", "!="}) == -1
+		comparison, err = compileComparison(column, comparison_type, relation.Right, options, ordered)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(row []string) (bool, error) {
+		result, err := comparison(row)
+		if err != nil {
+			return false, err
+		}
+		return test(result), nil
+	}, nil
+}
+
+func compileOperator(operator string) (func(comparison int) bool, error) {
+	if operator == "=" || operator == "==" {
+		return func(comparison int) bool { return comparison == 0 }, nil
+	} else if operator == "!=" {
+		return func(comparison int) bool { return comparison != 0 }, nil
+	} else if operator == ">" {
+		return func(comparison int) bool { return comparison > 0 }, nil
+	} else if operator == "<" {
+		return func(comparison int) bool { return comparison < 0 }, nil
+	} else if operator == "<=" {
+		return func(comparison int) bool { return comparison <= 0 }, nil
+	} else if operator == ">=" {
+		return func(comparison int) bool { return comparison >= 0 }, nil
+	}
+
+	return nil, errors.New("Unknown comparison operator: " + operator)
+}
+
+// compileComparison compares a column of type column_type with right, which
+// is either a constant or another column read as the same type. Bigints and
+// decimals are compared exactly. Booleans are only ever compared for
+// equality. Strings are ordered by the collation of the query, and compared
+// for equality, when not ordered, byte by byte or ignoring case.
+func compileComparison(column int, column_type int, right *queryNode, options QueryOptions, ordered bool) (rowComparison, error) {
+	var right_column int = -1
+	if right.Kind == column_node_kind {
+		right_column = right.Column
+	}
+
+	var constant string = right.Token.Value
+
+	if column_type == 1 {
+		var value int
+		if right_column == -1 {
+			var err error
+			value, err = parseInteger(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return func(row []string) (int, error) {
+			left, err := parseRowInteger(row[column])
+			if err != nil {
+				return 0, err
+			}
+
+			var right int = value
+			if right_column != -1 {
+				right, err = parseRowInteger(row[right_column])
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			return compareIntegers(left, right), nil
+		}, nil
+	} else if column_type == 2 {
+		var value float64
+		if right_column == -1 {
+			var err error
+			value, err = parseDouble(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return func(row []string) (int, error) {
+			left, err := parseRowDouble(row[column])
+			if err != nil {
+				return 0, err
+			}
+
+			var right float64 = value
+			if right_column != -1 {
+				right, err = parseRowDouble(row[right_column])
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			return compareDoubles(left, right), nil
+		}, nil
+	} else if column_type == 5 || column_type == 6 {
+		var value *big.Rat
+		if right_column == -1 {
+			var err error
+			value, err = parseExact(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return func(row []string) (int, error) {
+			left, err := parseRowExact(row[column])
+			if err != nil {
+				return 0, err
+			}
+
+			var right *big.Rat = value
+			if right_column != -1 {
+				right, err = parseRowExact(row[right_column])
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			return left.Cmp(right), nil
+		}, nil
+	} else if column_type == 3 {
+		var value string = strings.ToUpper(constant)
+		if right_column == -1 && value != "T" && value != "F" {
+			return nil, errors.New("Unable to convert comparison value to boolean; must either be T or F: " + value)
+		}
+
+		return func(row []string) (int, error) {
+			left, err := parseRowBoolean(row[column])
+			if err != nil {
+				return 0, err
+			}
+
+			var right string = value
+			if right_column != -1 {
+				right, err = parseRowBoolean(row[right_column])
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			if left != right {
+				return 1, nil
+			}
+			return 0, nil
+		}, nil
+	} else if column_type == 4 {
+		var collation Collation = options.Collation
+		var ignore_case bool = options.IgnoreCase
+
+		return func(row []string) (int, error) {
+			var right string = constant
+			if right_column != -1 {
+				right = row[right_column]
+			}
+
+			if ordered {
+				return collation.Compare(row[column], right), nil
+			} else if ignore_case && !strings.EqualFold(row[column], right) {
+				return 1, nil
+			} else if !ignore_case && row[column] != right {
+				return 1, nil
+			}
+			return 0, nil
+		}, nil
+	}
+
+	return nil, errors.New("Unknown column type: " + strconv.Itoa(column_type))
+}
+
+// compileIn turns the values of an IN list into a set of the column type,
+// so that `1.50` finds `1.5` and `t` finds `T`. Bigints and decimals are
+// kept as exact fractions, and strings ignoring case are folded as `=`
+// compares them.
+func compileIn(root *queryNode, column_type int, options QueryOptions) (queryPlan, error) {
+	var column int = root.Left.Column
+
+	if column_type == 1 {
+		var set map[int]bool = map[int]bool{}
+		for i := range root.Values {
+			value, err := parseInteger(root.Values[i].Token.Value)
+			if err != nil {
+				return nil, err
+			}
+			set[value] = true
+		}
+
+		return func(row []string) (bool, error) {
+			value, err := parseRowInteger(row[column])
+			return err == nil && set[value], err
+		}, nil
+	} else if column_type == 2 {
+		var set map[float64]bool = map[float64]bool{}
+		for i := range root.Values {
+			value, err := parseDouble(root.Values[i].Token.Value)
+			if err != nil {
+				return nil, err
+			}
+			set[value] = true
+		}
+
+		return func(row []string) (bool, error) {
+			value, err := parseRowDouble(row[column])
+			return err == nil && set[value], err
+		}, nil
+	} else if column_type == 5 || column_type == 6 {
+		var set map[string]bool = map[string]bool{}
+		for i := range root.Values {
+			value, err := parseExact(root.Values[i].Token.Value)
+			if err != nil {
+				return nil, err
+			}
+			set[value.RatString()] = true
+		}
+
+		return func(row []string) (bool, error) {
+			value, err := parseRowExact(row[column])
+			return err == nil && set[value.RatString()], err
+		}, nil
+	} else if column_type == 3 {
+		var set map[string]bool = map[string]bool{}
+		for i := range root.Values {
+			set[strings.ToUpper(root.Values[i].Token.Value)] = true
+		}
+
+		return func(row []string) (bool, error) {
+			value, err := parseRowBoolean(row[column])
+			return err == nil && set[value], err
+		}, nil
+	} else if column_type == 4 {
+		var ignore_case bool = options.IgnoreCase
+		var set map[string]bool = map[string]bool{}
+		for i := range root.Values {
+			var value string = root.Values[i].Token.Value
+			if ignore_case {
+				value = foldCase(value)
+			}
+			set[value] = true
+		}
+
+		return func(row []string) (bool, error) {
+			if ignore_case {
+				return set[foldCase(row[column])], nil
+			}
+			return set[row[column]], nil
+		}, nil
+	}
+
+	return nil, errors.New("Unknown column type: " + strconv.Itoa(column_type))
+}
+
+func parseRowInteger(value string) (int, error) {
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Unable to convert row value to integer: " + err.Error())
+	}
+	return result, nil
+}
+
+func parseRowDouble(value string) (float64, error) {
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.New("Unable to convert row value to double: " + err.Error())
+	}
+	return result, nil
+}
+
+// parseRowBoolean returns a boolean value of a row as T or F.
+func parseRowBoolean(value string) (string, error) {
+	if value == "T" || value == "F" {
+		return value, nil
+	}
+
+	var result string = strings.ToUpper(value)
+	if result != "T" && result != "F" {
+		return "", errors.New("Unable to convert row value to boolean; must either be T or F: " + result)
+	}
+	return result, nil
+}
+
+func compareIntegers(left int, right int) int {
+	if left < right {
+		return -1
+	} else if left > right {
+		return 1
+	}
+	return 0
+}
+
+func compareDoubles(left float64, right float64) int {
+	if left < right {
+		return -1
+	} else if left > right {
+		return 1
+	}
+	return 0
+}
+
+/**
+ * A queryNumber is the value of an arithmetic expression. It is an integer
+ * unless any value it was computed from was a double. Integer division
+ * truncates towards zero, and integer overflow is an error.
+**/
+type queryNumber struct {
+	double  bool
+	integer int
+	real    float64
+}
+
+const min_integer int = -1 << (strconv.IntSize - 1)
+
+func (n queryNumber) asDouble() float64 {
+	if n.double {
+		return n.real
+	}
+	return float64(n.integer)
+}
+
+func compareNumbers(left queryNumber, right queryNumber) int {
+	if left.double || right.double {
+		return compareDoubles(left.asDouble(), right.asDouble())
+	}
+	return compareIntegers(left.integer, right.integer)
+}
+
+// compileSum compiles an arithmetic expression checked by checkSum. Numbers
+// in it are parsed once, here.
+func compileSum(root *queryNode, column_types []int) (sumPlan, error) {
+	if root.Kind == arithmetic_node_kind {
+		left, err := compileSum(root.Left, column_types)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := compileSum(root.Right, column_types)
+		if err != nil {
+			return nil, err
+		}
+
+		var operator string = root.Token.Value
+		var expression string = prettyQuery(root)
+		return func(row []string) (queryNumber, error) {
+			left_value, err := left(row)
+			if err != nil {
+				return left_value, err
+			}
+
+			right_value, err := right(row)
+			if err != nil {
+				return right_value, err
+			}
+
+			return applyArithmetic(operator, left_value, right_value, expression)
+		}, nil
+	} else if root.Kind == negate_node_kind {
+		inner, err := compileSum(root.Left, column_types)
+		if err != nil {
+			return nil, err
+		}
+
+		var expression string = prettyQuery(root)
+		return func(row []string) (queryNumber, error) {
+			result, err := inner(row)
+			if err == nil && !result.double && result.integer == min_integer {
+				return result, errors.New("Integer overflow in `" + expression + "`")
+			}
+
+			result.integer = -result.integer
+			result.real = -result.real
+			return result, err
+		}, nil
+	} else if root.Kind == column_node_kind {
+		var column int = root.Column
+		if column_types[column] == 2 {
+			return func(row []string) (queryNumber, error) {
+				value, err := parseRowDouble(row[column])
+				return queryNumber{double: true, real: value}, err
+			}, nil
+		}
+
+		return func(row []string) (queryNumber, error) {
+			value, err := parseRowInteger(row[column])
+			return queryNumber{integer: value}, err
+		}, nil
+	}
+
+	var constant queryNumber
+	var err error
+	if isIntegerLiteral(root.Token.Value) {
+		constant.integer, err = parseInteger(root.Token.Value)
+	} else {
+		constant.double = true
+		constant.real, err = parseDouble(root.Token.Value)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(row []string) (queryNumber, error) {
+		return constant, nil
+	}, nil
+}
+
+func applyArithmetic(operator string, left queryNumber, right queryNumber, expression string) (queryNumber, error) {
+	var result queryNumber
+
+	if operator == "/" && right.asDouble() == 0 {
+		return result, errors.New("Division by zero in `" + expression + "`")
+	}
+
+	if left.double || right.double {
+		var a, b float64 = left.asDouble(), right.asDouble()
+		result.double = true
+		switch operator {
+		case "+":
+			result.real = a + b
+		case "-":
+			result.real = a - b
+		case "*":
+			result.real = a * b
+		case "/":
+			result.real = a / b
+		}
+	} else {
+		var a, b int = left.integer, right.integer
+		var overflow bool
+		switch operator {
+		case "+":
+			result.integer = a + b
+			overflow = (b > 0 && result.integer < a) || (b < 0 && result.integer > a)
+		case "-":
+			result.integer = a - b
+			overflow = (b < 0 && result.integer < a) || (b > 0 && result.integer > a)
+		case "*":
+			result.integer = a * b
+			overflow = a != 0 && (result.integer/a != b || (a == -1 && b == min_integer))
+		case "/":
+			result.integer = a / b
+			overflow = a == min_integer && b == -1
+		}
+
+		if overflow {
+			return result, errors.New("Integer overflow in `" + expression + "`")
+		}
+	}
+
+	return result, nil
+}
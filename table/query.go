@@ -0,0 +1,1274 @@
+package table
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func bytes_contains(needle byte, haystack []byte) int {
+	for i := range haystack {
+		if haystack[i] == needle {
+			return i
+		}
+	}
+	return -1
+}
+
+func strings_contains(needle string, haystack []string) int {
+	for i := range haystack {
+		if haystack[i] == needle {
+			return i
+		}
+	}
+	return -1
+}
+
+/**
+ * Value: literal token (string)
+ * Type:
+ *      Undefined:  -1
+ *      Operator:   0
+ *      Bareword:   1
+ *      Join:       2
+ *      String:     3
+ *      Number:     4
+ *      Group:      5
+ *      Separator:  6
+ *      Arithmetic: 7
+ * Offset: byte offset of the token in the query
+**/
+var unknown_token_type int = -1
+var operator_token_type int = 0
+var bareword_token_type int = 1
+var join_token_type int = 2
+var string_token_type int = 3
+var number_token_type int = 4
+var group_token_type int = 5
+var separator_token_type int = 6
+var arithmetic_token_type int = 7
+var token_types_to_names map[int]string = map[int]string{-1: "unknown", 0: "operator", 1: "bareword", 2: "join", 3: "string", 4: "number", 5: "group", 6: "separator", 7: "arithmetic"}
+
+type token struct {
+	Value  string
+	Type   int
+	Offset int
+}
+
+// Operators and joins, longest first so that the lexer takes `<=` over `<`.
+var query_operators []string = []string{"==", "!=", "<=", ">=", "=", "<", ">", "!", "~"}
+var query_joins []string = []string{"&&", "||", "&", "|"}
+
+// Barewords which are never values, so that a sign after them starts a
+// number: `Salary BETWEEN -5 AND 5`.
+var query_keywords []string = []string{"NOT", "LIKE", "ILIKE", "IN", "BETWEEN", "AND"}
+
+/**
+ * Number literals: an optional sign, then either a hexadecimal integer or a
+ * decimal number with an optional fraction and exponent.
+ *
+ *      5   -5   +5   0x1F   -0xff   1.5   .5   5.   1e6   1.5E-3
+**/
+var number_pattern *regexp.Regexp = regexp.MustCompile(`^[+-]?(0[xX][0-9a-fA-F]+|([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?)$`)
+
+func tokenizeQuery(query string) ([]token, error) {
+	var result []token
+
+	var whitespace_parts []byte = []byte(" \t\n")
+	var join_parts []byte = []byte("&|")
+	var operator_parts []byte = []byte("><=!~")
+	var bareword_parts []byte = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-")
+	var number_parts []byte = []byte("0123456789.")
+	var sign_parts []byte = []byte("+-")
+	var group_parts []byte = []byte("()")
+	var separator_parts []byte = []byte(",")
+	var arithmetic_parts []byte = []byte("+-*/")
+	var string_start byte = '\''
+	var string_end byte = '\''
+
+	for i := 0; i < len(query); i++ {
+		var current token
+		current.Type = unknown_token_type
+		current.Offset = i
+
+		// Ignore whitespace
+		if bytes_contains(query[i], whitespace_parts) != -1 {
+			continue
+		} else if bytes_contains(query[i], operator_parts) != -1 {
+			current.Value = longestPrefix(query[i:], query_operators)
+			current.Type = operator_token_type
+			i += len(current.Value) - 1
+		} else if bytes_contains(query[i], number_parts) != -1 || (bytes_contains(query[i], sign_parts) != -1 && startsNumber(query[i+1:]) && signStartsNumber(result)) {
+			current.Value = scanNumber(query[i:])
+			current.Type = number_token_type
+
+			if !number_pattern.MatchString(current.Value) {
+				return []token(nil), &QueryError{Query: query, Offset: current.Offset, Reason: "malformed number `" + current.Value + "`"}
+			}
+
+			i += len(current.Value) - 1
+		} else if bytes_contains(query[i], arithmetic_parts) != -1 {
+			// A `-` inside a bareword is part of it, so subtraction from a
+			// column needs a space: `Salary - 5`, not `Salary-5`.
+			current.Value += string(query[i])
+			current.Type = arithmetic_token_type
+		} else if bytes_contains(query[i], bareword_parts) != -1 {
+			current.Value += string(query[i])
+			current.Type = bareword_token_type
+
+			// Look ahead and catch next bareword part, if it exists; a `.`
+			// inside a bareword qualifies a column of a join: `abc.SSN`.
+			for i+1 < len(query) && (bytes_contains(query[i+1], bareword_parts) != -1 || query[i+1] == '.') {
+				current.Value += string(query[i+1])
+				i += 1
+			}
+		} else if bytes_contains(query[i], join_parts) != -1 {
+			current.Value = longestPrefix(query[i:], query_joins)
+			current.Type = join_token_type
+			i += len(current.Value) - 1
+		} else if bytes_contains(query[i], group_parts) != -1 {
+			// Parentheses are always single tokens
+			current.Value += string(query[i])
+			current.Type = group_token_type
+		} else if bytes_contains(query[i], separator_parts) != -1 {
+			current.Value += string(query[i])
+			current.Type = separator_token_type
+		} else if query[i] == string_start {
+			current.Type = string_token_type
+
+			// Take the string up to its end as is, so that multi-byte
+			// characters are kept whole; a doubled quote stands for one,
+			// as in 'O''Brien'.
+			for {
+				var length int = strings.IndexByte(query[i+1:], string_end)
+				if length == -1 {
+					return []token(nil), &QueryError{Query: query, Offset: current.Offset, Reason: "unterminated string"}
+				}
+
+				current.Value += query[i+1 : i+1+length]
+				i += length + 1
+				if i+1 >= len(query) || query[i+1] != string_end {
+					break
+				}
+
+				current.Value += string(string_end)
+				i += 1
+			}
+		} else {
+			character, _ := utf8.DecodeRuneInString(query[i:])
+			return []token(nil), &QueryError{Query: query, Offset: i, Reason: "unknown character `" + string(character) + "`"}
+		}
+
+		result = append(result, current)
+	}
+
+	return foldAggregates(result), nil
+}
+
+func startsNumber(text string) bool {
+	if len(text) > 0 && text[0] >= '0' && text[0] <= '9' {
+		return true
+	}
+	return len(text) > 1 && text[0] == '.' && text[1] >= '0' && text[1] <= '9'
+}
+
+// signStartsNumber reports whether a sign after the previous tokens starts
+// a number. After a value it is an arithmetic operator instead:
+// `Salary > -5`, but `Salary -5 > 0`.
+func signStartsNumber(previous []token) bool {
+	if len(previous) == 0 {
+		return true
+	}
+
+	var last token = previous[len(previous)-1]
+	if last.Type == number_token_type || last.Type == string_token_type || (last.Type == group_token_type && last.Value == ")") {
+		return false
+	} else if last.Type == bareword_token_type {
+		return strings_contains(strings.ToUpper(last.Value), query_keywords) != -1
+	}
+
+	return true
+}
+
+// scanNumber returns the number at the start of text: its sign and every
+// character which could continue it, so that a malformed number such as
+// `1.2.3` or `12abc` is reported whole.
+func scanNumber(text string) string {
+	var end int = 0
+	if text[0] == '-' || text[0] == '+' {
+		end = 1
+	}
+
+	var hex bool = strings.HasPrefix(text[end:], "0x") || strings.HasPrefix(text[end:], "0X")
+	for end < len(text) {
+		var c byte = text[end]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '.' {
+			end += 1
+		} else if (c == '-' || c == '+') && !hex && end > 0 && (text[end-1] == 'e' || text[end-1] == 'E') {
+			end += 1
+		} else {
+			break
+		}
+	}
+
+	return text[:end]
+}
+
+// parseInteger parses a decimal or hexadecimal integer literal.
+func parseInteger(text string) (int, error) {
+	var digits string = strings.TrimLeft(text, "+-")
+	var base int = 10
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		digits = digits[2:]
+		base = 16
+	}
+
+	if strings.HasPrefix(text, "-") {
+		digits = "-" + digits
+	}
+
+	result, err := strconv.ParseInt(digits, base, strconv.IntSize)
+	if err != nil && err.(*strconv.NumError).Err == strconv.ErrRange {
+		return 0, errors.New("integer `" + text + "` is out of range")
+	} else if err != nil {
+		return 0, errors.New("cannot convert `" + text + "` to integer")
+	}
+
+	return int(result), nil
+}
+
+// parseDouble parses any number literal as a double.
+func parseDouble(text string) (float64, error) {
+	if isIntegerLiteral(text) && strings.ContainsAny(text, "xX") {
+		result, err := parseInteger(text)
+		return float64(result), err
+	}
+
+	result, err := strconv.ParseFloat(text, 64)
+	if err != nil && err.(*strconv.NumError).Err == strconv.ErrRange {
+		return 0, errors.New("number `" + text + "` is out of range")
+	} else if err != nil {
+		return 0, errors.New("cannot convert `" + text + "` to double")
+	}
+
+	return result, nil
+}
+
+// isIntegerLiteral reports whether a number literal has neither a fraction
+// nor an exponent.
+func isIntegerLiteral(text string) bool {
+	if strings.Contains(text, "0x") || strings.Contains(text, "0X") {
+		return true
+	}
+	return !strings.ContainsAny(text, ".eE")
+}
+
+func longestPrefix(text string, options []string) string {
+	for i := range options {
+		if strings.HasPrefix(text, options[i]) {
+			return options[i]
+		}
+	}
+	return text[0:1]
+}
+
+/**
+ * The parsed form of a query. Every node keeps the token it was built from,
+ * whose offset locates the node in the query for error messages.
+ *
+ * Kind:
+ *      Relation:   0   Left Token Right, where Token is the operator;
+ *                      Pattern is the compiled pattern of LIKE, ILIKE or ~
+ *      And:        1   Left && Right
+ *      Or:         2   Left || Right
+ *      Not:        3   ! Left, where Token is `!` or NOT as typed
+ *      Column:     4   Token names a column; Column is its position
+ *      Literal:    5   Token is a string, number or bareword value
+ *      In:         6   Left IN (Values...)
+ *      Between:    7   Left BETWEEN Values[0] AND Values[1]
+ *      Arithmetic: 8   Left Token Right, where Token is +, -, * or /
+ *      Negate:     9   -Left
+ * Groups: number of parentheses typed around the node
+**/
+var relation_node_kind int = 0
+var and_node_kind int = 1
+var or_node_kind int = 2
+var not_node_kind int = 3
+var column_node_kind int = 4
+var literal_node_kind int = 5
+var in_node_kind int = 6
+var between_node_kind int = 7
+var arithmetic_node_kind int = 8
+var negate_node_kind int = 9
+
+type queryNode struct {
+	Kind    int
+	Token   token
+	Left    *queryNode
+	Right   *queryNode
+	Column  int
+	Groups  int
+	Pattern *regexp.Regexp
+	Values  []*queryNode
+}
+
+/**
+ * Grammar:
+ *      query       := [or] [GROUP BY bareword { "," bareword }] [HAVING or]
+ *                     [ORDER BY key { "," key }] [LIMIT number]
+ *                     [OFFSET number]
+ *      or          := and { ("|" | "||") and }
+ *      and         := unary { ("&" | "&&") unary }
+ *      unary       := ("!" | NOT) unary | primary
+ *      primary     := "(" or ")" | relation
+ *      relation    := sum operator sum
+ *                   | sum IN "(" operand { "," operand } ")"
+ *                   | sum BETWEEN operand AND operand
+ *      sum         := product { ("+" | "-") product }
+ *      product     := factor { ("*" | "/") factor }
+ *      factor      := "-" factor | "(" sum ")" | operand
+ *      operand     := bareword | string | number | aggregate
+ *      aggregate   := (COUNT | SUM | AVG | MIN | MAX) "(" ("*" | bareword) ")"
+ *      operator    := "=" | "==" | "!=" | "<" | "<=" | ">" | ">=" | "~"
+ *                   | LIKE | ILIKE
+ *      key         := bareword [ASC | DESC]
+ *
+ * NOT is only a keyword when it is not followed by an operator, so that a
+ * column may still be named NOT. LIKE, ILIKE, IN, BETWEEN and AND are
+ * keywords in any case. A `(` may open either a group of conditions or part
+ * of a sum; the parser tries a group first and falls back to a relation when
+ * the `)` is followed by an operator.
+ *
+ * The condition may be left out when the query starts with a clause, to
+ * group, order or page through every row. The clauses come in the order
+ * above, except that LIMIT and OFFSET may be swapped. GROUP, HAVING, ORDER,
+ * LIMIT and OFFSET are only keywords where a clause can start: after the
+ * condition, or at the start of the query when followed by BY, a column or
+ * a number. The lexer folds an aggregate into a single bareword, such as
+ * `AVG(Salary)`, which HAVING and ORDER BY resolve like a column (see
+ * aggregate.go).
+**/
+type queryParser struct {
+	query    string
+	tokens   []token
+	position int
+}
+
+// parseQueryText parses a query into the tree of its condition, which is
+// nil when there is none, and its clauses.
+func parseQueryText(query string) (*queryNode, queryClauses, error) {
+	var clauses queryClauses = queryClauses{Limit: -1}
+
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, clauses, err
+	}
+
+	var parser *queryParser = &queryParser{query: query, tokens: tokens}
+	if len(tokens) == 0 {
+		return nil, clauses, parser.fail(len(query), "empty query")
+	}
+
+	var result *queryNode
+	if !parser.startsWithClause() {
+		result, err = parser.parseOr()
+		if err != nil {
+			return nil, clauses, err
+		}
+	}
+
+	clauses, err = parser.parseClauses()
+	if err != nil {
+		return nil, clauses, err
+	}
+
+	if parser.peek() != nil {
+		return nil, clauses, parser.expected("a join, ORDER BY, LIMIT, OFFSET or the end of the query")
+	}
+
+	return result, clauses, nil
+}
+
+/**
+ * queryClauses follow the condition of a query and decide how the matched
+ * rows are grouped, which of them are returned, and in what order. Limit is
+ * -1 when there is no LIMIT.
+**/
+type queryClauses struct {
+	Group  []columnRef
+	Having *queryNode
+	Order  []orderKey
+	Limit  int
+	Offset int
+}
+
+// A columnRef names a column, or an aggregate, in a clause; checkClauses or
+// checkGrouping resolves it.
+type columnRef struct {
+	Token  token
+	Column int
+}
+
+// An orderKey sorts rows by one column.
+type orderKey struct {
+	columnRef
+	Descending bool
+}
+
+// The clauses in the order they must come in; LIMIT and OFFSET share a
+// place.
+var query_clauses []string = []string{"GROUP", "HAVING", "ORDER", "LIMIT", "OFFSET"}
+var query_clause_places []int = []int{0, 1, 2, 3, 3}
+
+var query_clause_names map[string]string = map[string]string{"GROUP": "GROUP BY", "HAVING": "HAVING", "ORDER": "ORDER BY", "LIMIT": "LIMIT", "OFFSET": "OFFSET"}
+
+// startsWithClause reports whether a query without a condition begins with
+// a clause, rather than with a column of the same name.
+func (p *queryParser) startsWithClause() bool {
+	if len(p.tokens) < 2 || p.tokens[0].Type != bareword_token_type {
+		return false
+	}
+
+	var keyword string = strings.ToUpper(p.tokens[0].Value)
+	if keyword == "GROUP" || keyword == "ORDER" {
+		return p.tokens[1].Type == bareword_token_type && strings.ToUpper(p.tokens[1].Value) == "BY"
+	} else if keyword == "HAVING" {
+		return p.tokens[1].Type == bareword_token_type
+	}
+	return (keyword == "LIMIT" || keyword == "OFFSET") && p.tokens[1].Type == number_token_type
+}
+
+func (p *queryParser) parseClauses() (queryClauses, error) {
+	var result queryClauses = queryClauses{Limit: -1}
+	var seen []string
+	var place int = -1
+
+	for p.peek() != nil && p.peek().Type == bareword_token_type {
+		var clause token = *p.peek()
+		var keyword string = strings.ToUpper(clause.Value)
+		var index int = strings_contains(keyword, query_clauses)
+		if index == -1 {
+			break
+		} else if strings_contains(keyword, seen) != -1 {
+			return result, p.fail(clause.Offset, query_clause_names[keyword]+" is given twice")
+		} else if query_clause_places[index] < place {
+			return result, p.fail(clause.Offset, query_clause_names[keyword]+" must come before "+query_clause_names[seen[len(seen)-1]])
+		}
+
+		seen = append(seen, keyword)
+		place = query_clause_places[index]
+		p.position += 1
+
+		if keyword == "GROUP" {
+			group, err := p.parseGroup()
+			if err != nil {
+				return result, err
+			}
+			result.Group = group
+			continue
+		} else if keyword == "HAVING" {
+			having, err := p.parseOr()
+			if err != nil {
+				return result, err
+			}
+			result.Having = having
+			continue
+		} else if keyword == "ORDER" {
+			order, err := p.parseOrder()
+			if err != nil {
+				return result, err
+			}
+			result.Order = order
+			continue
+		}
+
+		var next *token = p.peek()
+		if next == nil || next.Type != number_token_type {
+			return result, p.expected("a number of rows after " + keyword)
+		}
+
+		count, err := parseInteger(next.Value)
+		if err != nil {
+			return result, p.fail(next.Offset, err.Error())
+		} else if count < 0 {
+			return result, p.fail(next.Offset, keyword+" cannot be negative")
+		}
+		p.position += 1
+
+		if keyword == "LIMIT" {
+			result.Limit = count
+		} else {
+			result.Offset = count
+		}
+	}
+
+	return result, nil
+}
+
+// parseGroup parses the columns of GROUP BY, after the GROUP.
+func (p *queryParser) parseGroup() ([]columnRef, error) {
+	err := p.parseBy("GROUP")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []columnRef
+	for {
+		var next *token = p.peek()
+		if next == nil || next.Type != bareword_token_type {
+			return nil, p.expected("a column to group by")
+		}
+
+		result = append(result, columnRef{Token: *next, Column: -1})
+		p.position += 1
+
+		next = p.peek()
+		if next == nil || next.Type != separator_token_type {
+			return result, nil
+		}
+		p.position += 1
+	}
+}
+
+func (p *queryParser) parseBy(keyword string) error {
+	var next *token = p.peek()
+	if next == nil || next.Type != bareword_token_type || strings.ToUpper(next.Value) != "BY" {
+		return p.expected("BY after " + keyword)
+	}
+	p.position += 1
+	return nil
+}
+
+// parseOrder parses the keys of ORDER BY, after the ORDER.
+func (p *queryParser) parseOrder() ([]orderKey, error) {
+	err := p.parseBy("ORDER")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []orderKey
+	for {
+		var next *token = p.peek()
+		if next == nil || next.Type != bareword_token_type {
+			return nil, p.expected("a column to order by")
+		}
+
+		var key orderKey = orderKey{columnRef: columnRef{Token: *next, Column: -1}}
+		p.position += 1
+
+		next = p.peek()
+		if next != nil && next.Type == bareword_token_type && (strings.ToUpper(next.Value) == "ASC" || strings.ToUpper(next.Value) == "DESC") {
+			key.Descending = strings.ToUpper(next.Value) == "DESC"
+			p.position += 1
+		}
+
+		result = append(result, key)
+
+		next = p.peek()
+		if next == nil || next.Type != separator_token_type {
+			return result, nil
+		}
+		p.position += 1
+	}
+}
+
+func (p *queryParser) fail(offset int, reason string) error {
+	return &QueryError{Query: p.query, Offset: offset, Reason: reason}
+}
+
+// peek returns the next token, or nil at the end of the query.
+func (p *queryParser) peek() *token {
+	if p.position >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.position]
+}
+
+// expected fails with a message naming the next token, or the end of the
+// query if there is none.
+func (p *queryParser) expected(what string) error {
+	var next *token = p.peek()
+	if next == nil {
+		return p.fail(len(p.query), "expected "+what+" but the query ended")
+	}
+	return p.fail(next.Offset, "expected "+what+" but found `"+next.Value+"`")
+}
+
+func (p *queryParser) parseOr() (*queryNode, error) {
+	return p.parseJoin(or_node_kind, []string{"|", "||"}, p.parseAnd)
+}
+
+func (p *queryParser) parseAnd() (*queryNode, error) {
+	return p.parseJoin(and_node_kind, []string{"&", "&&"}, p.parseUnary)
+}
+
+// parseJoin parses a left to right chain of one kind of join.
+func (p *queryParser) parseJoin(kind int, joins []string, next func() (*queryNode, error)) (*queryNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() != nil && p.peek().Type == join_token_type && strings_contains(p.peek().Value, joins) != -1 {
+		var join token = *p.peek()
+		p.position += 1
+
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &queryNode{Kind: kind, Token: join, Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) isNot() bool {
+	var next *token = p.peek()
+	if next == nil {
+		return false
+	}
+
+	if next.Type == operator_token_type && next.Value == "!" {
+		return true
+	}
+
+	if next.Type != bareword_token_type || strings.ToUpper(next.Value) != "NOT" {
+		return false
+	}
+
+	if p.position+1 >= len(p.tokens) {
+		return true
+	}
+
+	var after token = p.tokens[p.position+1]
+	return !isComparison(after) && (after.Type != arithmetic_token_type || after.Value == "-")
+}
+
+func isComparison(operator token) bool {
+	if operator.Type == bareword_token_type {
+		var keyword string = strings.ToUpper(operator.Value)
+		return keyword == "LIKE" || keyword == "ILIKE" || keyword == "IN" || keyword == "BETWEEN"
+	}
+
+	return operator.Type == operator_token_type && operator.Value != "!"
+}
+
+func (p *queryParser) parseUnary() (*queryNode, error) {
+	if p.isNot() {
+		var not token = *p.peek()
+		p.position += 1
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &queryNode{Kind: not_node_kind, Token: not, Left: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (*queryNode, error) {
+	if !p.isOpen() {
+		return p.parseRelation()
+	}
+
+	var start int = p.position
+	group, group_err := p.parseClosed(p.parseOr, "`)` or a join")
+	if group_err == nil && !p.continuesSum() {
+		return group, nil
+	}
+
+	// The parentheses may instead surround part of a sum, as in
+	// `(Salary + Bonus) * 2 > 5`.
+	var group_position int = p.position
+	p.position = start
+	relation, err := p.parseRelation()
+	if err == nil {
+		return relation, nil
+	}
+
+	// Report whichever reading of the query got further.
+	if group_err != nil && group_position >= p.position {
+		return nil, group_err
+	}
+	return nil, err
+}
+
+func (p *queryParser) isOpen() bool {
+	var next *token = p.peek()
+	return next != nil && next.Type == group_token_type && next.Value == "("
+}
+
+// continuesSum reports whether the next token carries on a sum or relation
+// rather than ending a group.
+func (p *queryParser) continuesSum() bool {
+	var next *token = p.peek()
+	return next != nil && (next.Type == arithmetic_token_type || isComparison(*next))
+}
+
+// parseClosed parses `(`, whatever inner parses, and `)`.
+func (p *queryParser) parseClosed(inner func() (*queryNode, error), what string) (*queryNode, error) {
+	var open token = *p.peek()
+	p.position += 1
+
+	result, err := inner()
+	if err != nil {
+		return nil, err
+	}
+
+	var next *token = p.peek()
+	if next == nil {
+		return nil, p.fail(open.Offset, "unmatched `(`")
+	} else if next.Type != group_token_type || next.Value != ")" {
+		return nil, p.expected(what)
+	}
+	p.position += 1
+
+	result.Groups += 1
+	return result, nil
+}
+
+func (p *queryParser) parseRelation() (*queryNode, error) {
+	left, err := p.parseSum("a relation or `(`")
+	if err != nil {
+		return nil, err
+	}
+
+	var next *token = p.peek()
+	if next == nil || !isComparison(*next) {
+		return nil, p.expected("a comparison operator after `" + left.Token.Value + "`")
+	}
+
+	var operator token = *next
+	p.position += 1
+
+	if strings.ToUpper(operator.Value) == "IN" {
+		return p.parseIn(left, operator)
+	} else if strings.ToUpper(operator.Value) == "BETWEEN" {
+		return p.parseBetween(left, operator)
+	}
+
+	right, err := p.parseSum("a value after `" + operator.Value + "`")
+	if err != nil {
+		return nil, err
+	}
+
+	return &queryNode{Kind: relation_node_kind, Token: operator, Left: left, Right: right}, nil
+}
+
+func (p *queryParser) parseSum(what string) (*queryNode, error) {
+	return p.parseArithmetic([]string{"+", "-"}, func() (*queryNode, error) { return p.parseProduct(what) })
+}
+
+func (p *queryParser) parseProduct(what string) (*queryNode, error) {
+	return p.parseArithmetic([]string{"*", "/"}, func() (*queryNode, error) { return p.parseFactor(what) })
+}
+
+// parseArithmetic parses a left to right chain of operators of the same
+// precedence.
+func (p *queryParser) parseArithmetic(operators []string, next func() (*queryNode, error)) (*queryNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() != nil && p.peek().Type == arithmetic_token_type && strings_contains(p.peek().Value, operators) != -1 {
+		var operator token = *p.peek()
+		p.position += 1
+
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &queryNode{Kind: arithmetic_node_kind, Token: operator, Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) parseFactor(what string) (*queryNode, error) {
+	var next *token = p.peek()
+	if next != nil && next.Type == arithmetic_token_type && next.Value == "-" {
+		var minus token = *next
+		p.position += 1
+
+		operand, err := p.parseFactor("a value after `-`")
+		if err != nil {
+			return nil, err
+		}
+
+		return &queryNode{Kind: negate_node_kind, Token: minus, Left: operand}, nil
+	}
+
+	if p.isOpen() {
+		return p.parseClosed(func() (*queryNode, error) { return p.parseSum("a value after `(`") }, "`)` or an arithmetic operator")
+	}
+
+	return p.parseOperand(what)
+}
+
+func (p *queryParser) parseIn(left *queryNode, operator token) (*queryNode, error) {
+	var next *token = p.peek()
+	if next == nil || next.Type != group_token_type || next.Value != "(" {
+		return nil, p.expected("`(` after `" + operator.Value + "`")
+	}
+	p.position += 1
+
+	var result *queryNode = &queryNode{Kind: in_node_kind, Token: operator, Left: left}
+	for {
+		value, err := p.parseOperand("a value in the list")
+		if err != nil {
+			return nil, err
+		}
+		result.Values = append(result.Values, value)
+
+		next = p.peek()
+		if next != nil && next.Type == separator_token_type {
+			p.position += 1
+		} else if next != nil && next.Type == group_token_type && next.Value == ")" {
+			p.position += 1
+			return result, nil
+		} else {
+			return nil, p.expected("`,` or `)` in the list")
+		}
+	}
+}
+
+func (p *queryParser) parseBetween(left *queryNode, operator token) (*queryNode, error) {
+	low, err := p.parseOperand("a value after `" + operator.Value + "`")
+	if err != nil {
+		return nil, err
+	}
+
+	var next *token = p.peek()
+	if next == nil || next.Type != bareword_token_type || strings.ToUpper(next.Value) != "AND" {
+		return nil, p.expected("AND after `" + low.Token.Value + "`")
+	}
+	p.position += 1
+
+	high, err := p.parseOperand("a value after AND")
+	if err != nil {
+		return nil, err
+	}
+
+	return &queryNode{Kind: between_node_kind, Token: operator, Left: left, Values: []*queryNode{low, high}}, nil
+}
+
+func (p *queryParser) parseOperand(what string) (*queryNode, error) {
+	var next *token = p.peek()
+	if next == nil || (next.Type != bareword_token_type && next.Type != string_token_type && next.Type != number_token_type) {
+		return nil, p.expected(what)
+	}
+
+	p.position += 1
+	return &queryNode{Kind: literal_node_kind, Token: *next, Column: -1}, nil
+}
+
+/**
+ * checkQuery resolves the column of every relation and checks that the
+ * operator and values suit the column type. The left side of a relation
+ * must name a column; the right side, the IN list and the BETWEEN bounds
+ * are values. A relation with a value on the left and a column on the
+ * right, such as `100 < Salary`, is turned around into `Salary > 100`
+ * first. A bareword on the right side of a relation which names a
+ * column compares against that column of the same row, so its type must be
+ * compatible: integers, doubles, bigints and decimals compare with each
+ * other, and other types only with themselves. When either side of a
+ * relation is arithmetic, both sides are checked by checkSum and compared
+ * as numbers.
+**/
+func checkQuery(query string, root *queryNode, column_names []string, column_types []int) error {
+	var fail = func(offset int, reason string) error {
+		return &QueryError{Query: query, Offset: offset, Reason: reason}
+	}
+
+	if root.Kind == and_node_kind || root.Kind == or_node_kind {
+		err := checkQuery(query, root.Left, column_names, column_types)
+		if err != nil {
+			return err
+		}
+		return checkQuery(query, root.Right, column_names, column_types)
+	} else if root.Kind == not_node_kind {
+		return checkQuery(query, root.Left, column_names, column_types)
+	} else if root.Kind != relation_node_kind && root.Kind != in_node_kind && root.Kind != between_node_kind {
+		return fail(root.Token.Offset, "expected a relation")
+	}
+
+	var valid_number_operators []string = []string{"==", "=", "!=", ">", "<", "<=", ">=", "IN", "BETWEEN"}
+	var valid_string_operators []string = []string{"==", "=", "!=", ">", "<", "<=", ">=", "IN", "BETWEEN"}
+	var valid_boolean_operators []string = []string{"==", "=", "!=", "IN"}
+	var valid_pattern_operators []string = []string{"LIKE", "ILIKE", "~"}
+	var valid_boolean_types []string = []string{"t", "T", "f", "F"}
+
+	var column *queryNode = root.Left
+	var operator token = root.Token
+	var keyword string = strings.ToUpper(operator.Value)
+
+	if root.Kind == relation_node_kind && (isArithmetic(root.Left) || isArithmetic(root.Right)) {
+		if strings_contains(keyword, valid_number_operators) == -1 {
+			return fail(operator.Offset, "unknown operator for numbers: "+operator.Value)
+		}
+
+		_, err := checkSum(query, root.Left, column_names, column_types)
+		if err != nil {
+			return err
+		}
+
+		_, err = checkSum(query, root.Right, column_names, column_types)
+		return err
+	}
+
+	if root.Kind == relation_node_kind && !namesColumn(root.Left, column_names) && namesColumn(root.Right, column_names) {
+		if strings_contains(keyword, valid_pattern_operators) != -1 {
+			return fail(root.Left.Token.Offset, "`"+operator.Value+"` takes the column on its left and the pattern on its right")
+		}
+
+		root.Left, root.Right = root.Right, root.Left
+		root.Token.Value = flipOperator(root.Token.Value)
+		column = root.Left
+	} else if root.Kind == relation_node_kind && column.Token.Type != bareword_token_type && root.Right.Token.Type == bareword_token_type {
+		return fail(root.Right.Token.Offset, unknownColumn(root.Right.Token.Value))
+	}
+
+	if column.Token.Type != bareword_token_type && root.Kind == relation_node_kind {
+		return fail(startOffset(column), "expected a column name on either side of `"+operator.Value+"`")
+	} else if column.Token.Type != bareword_token_type {
+		return fail(startOffset(column), "expected a column name on the left of `"+operator.Value+"`")
+	}
+
+	var found_column_id int = strings_contains(column.Token.Value, column_names)
+	if found_column_id == -1 {
+		return fail(column.Token.Offset, unknownColumn(column.Token.Value))
+	}
+
+	column.Kind = column_node_kind
+	column.Column = found_column_id
+
+	var column_type ColumnType = ColumnType(column_types[found_column_id])
+
+	var values []*queryNode = root.Values
+	if root.Kind == relation_node_kind {
+		values = []*queryNode{root.Right}
+
+		var right_column_id int = -1
+		if root.Right.Token.Type == bareword_token_type {
+			right_column_id = strings_contains(root.Right.Token.Value, column_names)
+		}
+
+		if right_column_id != -1 {
+			var right_type ColumnType = ColumnType(column_types[right_column_id])
+			if !(column_type.numeric() && right_type.numeric()) && right_type != column_type {
+				return fail(root.Right.Token.Offset, "cannot compare column "+column.Token.Value+" of type "+column_type.String()+" with column "+root.Right.Token.Value+" of type "+right_type.String())
+			}
+
+			root.Right.Kind = column_node_kind
+			root.Right.Column = right_column_id
+			values = nil
+		}
+	}
+
+	if column_type.numeric() {
+		if strings_contains(keyword, valid_number_operators) == -1 {
+			return fail(operator.Offset, "unknown operator for numbers: "+operator.Value)
+		}
+	} else if column_type == String && strings_contains(keyword, valid_pattern_operators) != -1 {
+		if root.Right.Kind == column_node_kind {
+			return fail(root.Right.Token.Offset, "the pattern of `"+operator.Value+"` must be a value, not the column "+root.Right.Token.Value)
+		}
+
+		if root.Right.Token.Type == number_token_type {
+			return fail(root.Right.Token.Offset, "column "+column.Token.Value+" is not of numerical type: "+column_type.String()+" vs "+root.Right.Token.Value)
+		}
+
+		pattern, err := compilePattern(keyword, root.Right.Token.Value)
+		if err != nil {
+			return fail(root.Right.Token.Offset, "invalid pattern: "+err.Error())
+		}
+
+		root.Pattern = pattern
+		return nil
+	} else if column_type == String && strings_contains(keyword, valid_string_operators) == -1 {
+		return fail(operator.Offset, "unknown operator for "+column_type.String()+" columns: "+operator.Value)
+	} else if column_type == Boolean && strings_contains(keyword, valid_boolean_operators) == -1 {
+		return fail(operator.Offset, "unknown operator for "+column_type.String()+" columns: "+operator.Value)
+	}
+
+	for i := range values {
+		var value token = values[i].Token
+
+		if column_type.numeric() {
+			if value.Type != number_token_type {
+				return fail(value.Offset, "column "+column.Token.Value+" is of numerical type "+column_type.String()+" but `"+value.Value+"` is not a number")
+			}
+		} else {
+			if value.Type == number_token_type {
+				return fail(value.Offset, "column "+column.Token.Value+" is not of numerical type: "+column_type.String()+" vs "+value.Value)
+			}
+
+			if column_type == Boolean && strings_contains(value.Value, valid_boolean_types) == -1 {
+				return fail(value.Offset, "search value is not of boolean type: "+value.Value)
+			}
+		}
+
+		var err error
+		if column_type == Integer {
+			_, err = parseInteger(value.Value)
+		} else if column_type == Double {
+			_, err = parseDouble(value.Value)
+		} else if column_type == BigInteger {
+			_, err = parseBigInteger(value.Value)
+		} else if column_type == Decimal {
+			_, err = parseExact(value.Value)
+		}
+
+		if err != nil {
+			return fail(value.Offset, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// checkClauses resolves the columns of ORDER BY. Every column type can be
+// ordered: numbers by value, booleans with F first and strings by the
+// collation of the query.
+func checkClauses(query string, clauses queryClauses, column_names []string) error {
+	for i := range clauses.Order {
+		var key *orderKey = &clauses.Order[i]
+		key.Column = strings_contains(key.Token.Value, column_names)
+		if key.Column == -1 {
+			return &QueryError{Query: query, Offset: key.Token.Offset, Reason: unknownColumn(key.Token.Value)}
+		}
+	}
+
+	return nil
+}
+
+// namesColumn reports whether a side of a relation is a bareword naming one
+// of the columns.
+func namesColumn(root *queryNode, column_names []string) bool {
+	return root.Kind == literal_node_kind && root.Token.Type == bareword_token_type && strings_contains(root.Token.Value, column_names) != -1
+}
+
+// flipOperator returns the operator which compares the same way with its
+// sides swapped.
+func flipOperator(operator string) string {
+	switch operator {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return operator
+}
+
+func isArithmetic(root *queryNode) bool {
+	return root.Kind == arithmetic_node_kind || root.Kind == negate_node_kind
+}
+
+// startOffset returns the offset of the first token of an expression.
+func startOffset(root *queryNode) int {
+	if root.Kind == arithmetic_node_kind {
+		return startOffset(root.Left)
+	}
+	return root.Token.Offset
+}
+
+func unknownColumn(name string) string {
+	if IsAggregate(name) {
+		return "the aggregate `" + name + "` can only be used in HAVING and ORDER BY"
+	} else if strings.Contains(name, "-") {
+		return "unknown column `" + name + "`; put spaces around `-` to subtract"
+	}
+	return "unknown column `" + name + "`"
+}
+
+// checkSum resolves the columns of an arithmetic expression and returns its
+// type: Integer if every value in it is an integer, otherwise Double.
+func checkSum(query string, root *queryNode, column_names []string, column_types []int) (ColumnType, error) {
+	var fail = func(offset int, reason string) (ColumnType, error) {
+		return 0, &QueryError{Query: query, Offset: offset, Reason: reason}
+	}
+
+	if root.Kind == arithmetic_node_kind {
+		left, err := checkSum(query, root.Left, column_names, column_types)
+		if err != nil {
+			return 0, err
+		}
+
+		right, err := checkSum(query, root.Right, column_names, column_types)
+		if err != nil {
+			return 0, err
+		}
+
+		if left == Double || right == Double {
+			return Double, nil
+		}
+		return Integer, nil
+	} else if root.Kind == negate_node_kind {
+		return checkSum(query, root.Left, column_names, column_types)
+	}
+
+	var value token = root.Token
+	if value.Type == bareword_token_type {
+		var found_column_id int = strings_contains(value.Value, column_names)
+		if found_column_id == -1 {
+			return fail(value.Offset, unknownColumn(value.Value))
+		}
+
+		var column_type ColumnType = ColumnType(column_types[found_column_id])
+		if column_type != Integer && column_type != Double {
+			return fail(value.Offset, "column "+value.Value+" is of type "+column_type.String()+" and cannot be used in arithmetic")
+		}
+
+		root.Kind = column_node_kind
+		root.Column = found_column_id
+		return column_type, nil
+	} else if value.Type == number_token_type {
+		if isIntegerLiteral(value.Value) {
+			if _, err := parseInteger(value.Value); err != nil {
+				return fail(value.Offset, err.Error())
+			}
+			return Integer, nil
+		}
+
+		if _, err := parseDouble(value.Value); err != nil {
+			return fail(value.Offset, err.Error())
+		}
+		return Double, nil
+	}
+
+	return fail(value.Offset, "`"+value.Value+"` is not a number")
+}
+
+/**
+ * compilePattern turns the pattern of a LIKE, ILIKE or ~ relation into a
+ * regular expression, once per query.
+ *
+ * LIKE and ILIKE match the whole value: `%` matches any run of characters,
+ * `_` matches a single character, and a backslash makes the character after
+ * it literal. ILIKE ignores case. ~ is a Go regular expression, which matches
+ * anywhere in the value unless anchored with ^ or $.
+**/
+func compilePattern(operator string, pattern string) (*regexp.Regexp, error) {
+	if operator == "~" {
+		return regexp.Compile(pattern)
+	}
+
+	var expression string = "(?s)"
+	if operator == "ILIKE" {
+		expression += "(?i)"
+	}
+	expression += "^"
+
+	var characters []rune = []rune(pattern)
+	for i := 0; i < len(characters); i++ {
+		if characters[i] == '%' {
+			expression += ".*"
+		} else if characters[i] == '_' {
+			expression += "."
+		} else {
+			if characters[i] == '\\' && i+1 < len(characters) {
+				i += 1
+			}
+			expression += regexp.QuoteMeta(string(characters[i]))
+		}
+	}
+
+	return regexp.Compile(expression + "$")
+}
+
+// prettyClauses prints the clauses of a query, each after a space.
+func prettyClauses(clauses queryClauses) string {
+	var result string
+	for i := range clauses.Group {
+		if i == 0 {
+			result += " GROUP BY "
+		} else {
+			result += ", "
+		}
+		result += clauses.Group[i].Token.Value
+	}
+
+	if clauses.Having != nil {
+		result += " HAVING " + prettyQuery(clauses.Having)
+	}
+
+	for i := range clauses.Order {
+		if i == 0 {
+			result += " ORDER BY "
+		} else {
+			result += ", "
+		}
+
+		result += clauses.Order[i].Token.Value
+		if clauses.Order[i].Descending {
+			result += " DESC"
+		}
+	}
+
+	if clauses.Limit != -1 {
+		result += " LIMIT " + strconv.Itoa(clauses.Limit)
+	}
+	if clauses.Offset != 0 {
+		result += " OFFSET " + strconv.Itoa(clauses.Offset)
+	}
+
+	return result
+}
+
+// prettyQuery prints the query as it would be typed, with the parentheses
+// which were typed.
+func prettyQuery(root *queryNode) string {
+	if root == nil {
+		return ""
+	}
+
+	var result string
+	if root.Kind == relation_node_kind {
+		result = prettyQuery(root.Left) + " " + root.Token.Value + " " + prettyQuery(root.Right)
+	} else if root.Kind == in_node_kind {
+		var values []string
+		for i := range root.Values {
+			values = append(values, prettyQuery(root.Values[i]))
+		}
+		result = prettyQuery(root.Left) + " " + root.Token.Value + " (" + strings.Join(values, ", ") + ")"
+	} else if root.Kind == between_node_kind {
+		result = prettyQuery(root.Left) + " " + root.Token.Value + " " + prettyQuery(root.Values[0]) + " AND " + prettyQuery(root.Values[1])
+	} else if root.Kind == arithmetic_node_kind {
+		result = prettyQuery(root.Left) + " " + root.Token.Value + " " + prettyQuery(root.Right)
+	} else if root.Kind == negate_node_kind {
+		result = "-" + prettyQuery(root.Left)
+	} else if root.Kind == and_node_kind {
+		result = prettyQuery(root.Left) + " && " + prettyQuery(root.Right)
+	} else if root.Kind == or_node_kind {
+		result = prettyQuery(root.Left) + " || " + prettyQuery(root.Right)
+	} else if root.Kind == not_node_kind {
+		if root.Token.Value == "!" {
+			result = "!" + prettyQuery(root.Left)
+		} else {
+			result = root.Token.Value + " " + prettyQuery(root.Left)
+		}
+	} else if root.Token.Type == string_token_type {
+		result = "'" + strings.Replace(root.Token.Value, "'", "''", -1) + "'"
+	} else {
+		result = root.Token.Value
+	}
+
+	for i := 0; i < root.Groups; i++ {
+		result = "(" + result + ")"
+	}
+
+	return result
+}
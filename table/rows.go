@@ -0,0 +1,367 @@
+package table
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// Missing is set on a row of a left join which joined no row of the right
+// table; its values for the columns of that table are empty.
+type Row struct {
+	RID     int
+	Values  []string
+	Missing bool
+}
+
+/**
+ * Rows iterates over the records of a table, in file order unless the query
+ * orders them:
+ *
+ *      rows, err := t.Rows()
+ *      ...
+ *      defer rows.Close()
+ *      for rows.Next() {
+ *          row := rows.Row()
+ *          ...
+ *      }
+ *      err = rows.Err()
+**/
+type Rows struct {
+	table   *Table
+	file    *os.File
+	records recordReader
+	query   *Query
+	lock    *lock
+	current Row
+	err     error
+	indexes []string
+	sorter  *rowSorter
+	skipped int
+	count   int
+}
+
+/**
+ * A recordReader walks the records of one storage format, returning io.EOF
+ * after the last one. Deleted records are returned with live set to false.
+ * The location of a record is the byte offset of its line in the text
+ * format and of its slot in the page format; indexes store it to read the
+ * record back directly.
+**/
+type recordReader interface {
+	next() (row_id int, live bool, values []string, err error)
+	location() int64
+}
+
+func (t *Table) Rows() (*Rows, error) {
+	return t.scan(nil)
+}
+
+// scan holds a shared lock on the table until the returned Rows is closed.
+func (t *Table) scan(query *Query) (*Rows, error) {
+	l, err := t.acquire(false)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := t.read(query)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+
+	result.lock = l
+	return result, nil
+}
+
+// read is scan for a caller which already holds a lock on the table, such
+// as a statement which changes the rows it finds (see change.go).
+func (t *Table) read(query *Query) (*Rows, error) {
+	f, err := os.Open(t.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+
+	var records recordReader
+	var indexes []string
+	if query != nil {
+		records, indexes, err = t.planSearch(f, query)
+	}
+
+	if records == nil && err == nil {
+		records, err = newRecordReader(f, t.filename, t.header)
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var result *Rows = &Rows{table: t, file: f, records: records, query: query, indexes: indexes}
+	return result, nil
+}
+
+func newRecordReader(f *os.File, filename string, h header) (recordReader, error) {
+	if h.format == PageFormat {
+		return newPageReader(f, h), nil
+	}
+
+	return newTextReader(f, filename, h)
+}
+
+// Next moves to the next row, skipping the OFFSET of the query and stopping
+// after its LIMIT.
+func (r *Rows) Next() bool {
+	if r.query == nil {
+		return r.nextMatch()
+	}
+
+	for r.skipped < r.query.clauses.Offset {
+		if !r.nextOrdered() {
+			return false
+		}
+		r.skipped += 1
+	}
+
+	if r.query.clauses.Limit != -1 && r.count >= r.query.clauses.Limit {
+		return false
+	}
+
+	if !r.nextOrdered() {
+		return false
+	}
+	r.count += 1
+	return true
+}
+
+// nextOrdered moves to the next matching row in the order of the query,
+// sorting every matching row the first time it is called.
+func (r *Rows) nextOrdered() bool {
+	if len(r.query.clauses.Order) == 0 {
+		return r.nextMatch()
+	}
+
+	if r.sorter == nil {
+		r.sorter = newRowSorter(r.query.clauses, r.query.column_types, r.query.options.Collation)
+		r.sorter.missing = r.query.missing
+		for r.nextMatch() {
+			r.err = r.sorter.add(r.current)
+			if r.err != nil {
+				return false
+			}
+		}
+
+		if r.err != nil {
+			return false
+		}
+
+		r.err = r.sorter.finish()
+		if r.err != nil {
+			return false
+		}
+	}
+
+	if r.err != nil {
+		return false
+	}
+
+	row, ok, err := r.sorter.next()
+	if err != nil || !ok {
+		r.err = err
+		return false
+	}
+
+	r.current = row
+	return true
+}
+
+// nextMatch moves to the next row in file order which matches the query.
+func (r *Rows) nextMatch() bool {
+	if r.err != nil || r.records == nil {
+		return false
+	}
+
+	for {
+		row_id, live, values, err := r.records.next()
+		if err == io.EOF {
+			return false
+		} else if err != nil {
+			r.err = err
+			return false
+		}
+
+		if !live {
+			continue
+		}
+
+		var missing bool = false
+		if join, ok := r.records.(*joinReader); ok {
+			missing = join.missing
+		}
+
+		if r.query != nil {
+			matched, err := r.query.matchRow(values, missing)
+			if err != nil {
+				r.err = &RowError{RID: row_id, Reason: err.Error()}
+				return false
+			}
+
+			if !matched {
+				continue
+			}
+		}
+
+		r.current = Row{RID: row_id, Values: values, Missing: missing}
+		return true
+	}
+}
+
+func (r *Rows) Row() Row {
+	return r.current
+}
+
+// Indexes returns the columns whose indexes were used to find the rows.
+func (r *Rows) Indexes() []string {
+	return r.indexes
+}
+
+func (r *Rows) Err() error {
+	return r.err
+}
+
+func (r *Rows) Close() error {
+	r.records = nil
+	if r.sorter != nil {
+		r.sorter.close()
+	}
+	if r.file == nil {
+		return nil
+	}
+
+	var err error = r.file.Close()
+	r.file = nil
+
+	var lock_err error = r.lock.release()
+	if err == nil {
+		err = lock_err
+	}
+
+	return err
+}
+
+func (t *Table) Row(row_id int) (Row, error) {
+	rows, err := t.Rows()
+	if err != nil {
+		return Row{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if rows.Row().RID == row_id {
+			return rows.Row(), nil
+		}
+	}
+
+	if rows.Err() != nil {
+		return Row{}, rows.Err()
+	}
+
+	return Row{}, &NotFoundError{RID: row_id}
+}
+
+type textReader struct {
+	filename string
+	h        header
+	scanner  *bufio.Scanner
+	line     int
+	offset   int64
+	previous int64
+}
+
+func newTextReader(f *os.File, filename string, h header) (*textReader, error) {
+	s := bufio.NewScanner(committedReader(f, h))
+
+	// Skip the header; it was validated by refresh.
+	if !s.Scan() {
+		if s.Err() != nil {
+			return nil, s.Err()
+		}
+		return nil, &FormatError{Filename: filename, Line: 0, Reason: "empty file"}
+	}
+
+	var result *textReader = &textReader{filename: filename, h: h, scanner: s, offset: int64(len(s.Text()) + 1)}
+	return result, nil
+}
+
+func (r *textReader) next() (int, bool, []string, error) {
+	if !r.scanner.Scan() {
+		if r.scanner.Err() != nil {
+			return -1, false, nil, r.scanner.Err()
+		}
+		return -1, false, nil, io.EOF
+	}
+
+	r.line += 1
+	r.previous = r.offset
+	r.offset += int64(len(r.scanner.Text()) + 1)
+
+	row_id, live, values, err := parseRecord(r.scanner.Text(), len(r.h.columns), r.h.version)
+	if err != nil {
+		return -1, false, nil, &FormatError{Filename: r.filename, Line: r.line, Reason: err.Error()}
+	}
+
+	// Older versions have no stored id; the id is the position.
+	if row_id == -1 {
+		row_id = r.line - 1
+	}
+
+	return row_id, live, values, nil
+}
+
+func (r *textReader) location() int64 {
+	return r.previous
+}
+
+// committedReader limits reads of a table file to its committed contents.
+func committedReader(f *os.File, h header) io.Reader {
+	if h.version >= 3 {
+		return io.LimitReader(f, h.size)
+	}
+
+	return f
+}
+
+// readLines loads every record line of a table into memory, without the
+// header. Lines from older format versions are re-encoded in the current
+// version, using their position as their row id, ready to be written back
+// out. The caller must hold a lock on the table.
+func readLines(filename string, h header) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var result []string
+	s := bufio.NewScanner(committedReader(f, h))
+	s.Scan()
+	for s.Scan() {
+		var line string = s.Text()
+
+		if h.version != currentVersion {
+			_, _, values, err := parseRecord(line, len(h.columns), h.version)
+			if err != nil {
+				return nil, &FormatError{Filename: filename, Line: len(result) + 1, Reason: err.Error()}
+			}
+
+			line = formatRecord(len(result), values)
+		}
+
+		result = append(result, line)
+	}
+
+	return result, s.Err()
+}
@@ -0,0 +1,447 @@
+package table
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * A Query is a parsed and validated search condition for a particular table
+ * schema, compiled into a plan for matching rows, along with the order and
+ * the range of the matched rows to return (see order.go). A query with
+ * GROUP BY, HAVING or an aggregate in ORDER BY is grouped, and is read with
+ * Aggregate rather than Search (see aggregate.go). It is safe to reuse a
+ * Query for any table with the same schema. In the query of a left join,
+ * missing is the first column of the right table, which a row with Missing
+ * set has no values for (see join.go).
+**/
+type Query struct {
+	text         string
+	root         *queryNode
+	clauses      queryClauses
+	grouping     *queryGrouping
+	missing      int
+	plan         queryPlan
+	column_names []string
+	column_types []int
+	options      QueryOptions
+}
+
+/**
+ * QueryOptions change how a query compares strings. Collation orders strings
+ * for <, <=, >, >= and BETWEEN; IgnoreCase makes =, != and IN ignore case.
+ * Both leave LIKE, ILIKE and ~ alone.
+**/
+type QueryOptions struct {
+	Collation  Collation
+	IgnoreCase bool
+}
+
+// DefaultQueryOptions are the options used by ParseQuery.
+var DefaultQueryOptions QueryOptions = QueryOptions{Collation: BinaryCollation}
+
+func ParseQuery(query string, columns []Column) (*Query, error) {
+	return ParseQueryWithOptions(query, columns, DefaultQueryOptions)
+}
+
+func ParseQueryWithOptions(query string, columns []Column, options QueryOptions) (*Query, error) {
+	return parseQuery(query, columns, options, false)
+}
+
+// MatchAll returns a query with no condition and no clauses, which matches
+// every row. A query parsed from text always has one or the other.
+func MatchAll(columns []Column, options QueryOptions) *Query {
+	var result *Query = &Query{clauses: queryClauses{Limit: -1}, options: options}
+
+	for i := range columns {
+		result.column_names = append(result.column_names, columns[i].Name)
+		result.column_types = append(result.column_types, int(columns[i].Type))
+	}
+
+	result.plan, _ = compileQuery(nil, result.column_types, options)
+	return result
+}
+
+// parseQuery parses a query of a table or, when qualified, of a join, whose
+// columns are named `table.column` (see join.go).
+func parseQuery(query string, columns []Column, options QueryOptions, qualified bool) (*Query, error) {
+	if _, ok := collationNames[options.Collation]; !ok {
+		return nil, errors.New("unknown collation: " + strconv.Itoa(int(options.Collation)))
+	}
+
+	var result *Query = &Query{text: query, options: options}
+
+	for i := range columns {
+		result.column_names = append(result.column_names, columns[i].Name)
+		result.column_types = append(result.column_types, int(columns[i].Type))
+	}
+
+	root, clauses, err := parseQueryText(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if qualified {
+		err = qualifyColumns(query, root, clauses, result.column_names)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if root != nil {
+		err = checkQuery(query, root, result.column_names, result.column_types)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isGrouped(clauses) {
+		result.grouping, err = checkGrouping(query, clauses, result.column_names, result.column_types, options)
+	} else {
+		err = checkClauses(query, clauses, result.column_names)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.plan, err = compileQuery(root, result.column_types, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result.root = root
+	result.clauses = clauses
+	return result, nil
+}
+
+func (q *Query) Text() string {
+	return q.text
+}
+
+func (q *Query) Options() QueryOptions {
+	return q.options
+}
+
+// String returns the query as it will be evaluated, with the parentheses
+// which were typed.
+func (q *Query) String() string {
+	if q.root == nil {
+		return strings.TrimPrefix(prettyClauses(q.clauses), " ")
+	}
+	return prettyQuery(q.root) + prettyClauses(q.clauses)
+}
+
+// Grouped reports whether the query groups rows, so that it must be read
+// with Aggregate.
+func (q *Query) Grouped() bool {
+	return q.grouping != nil
+}
+
+func (q *Query) Match(values []string) (bool, error) {
+	return q.plan(values)
+}
+
+// matchRow matches the values of a row, which are those of a missing row of
+// a left join if missing is set. A condition which cannot be evaluated on
+// the empty values of the right table is false for such a row.
+func (q *Query) matchRow(values []string, missing bool) (bool, error) {
+	matched, err := q.plan(values)
+	if err != nil && missing {
+		return false, nil
+	}
+	return matched, err
+}
+
+func (t *Table) ParseQuery(query string) (*Query, error) {
+	return ParseQuery(query, t.columns)
+}
+
+func (t *Table) ParseQueryWithOptions(query string, options QueryOptions) (*Query, error) {
+	return ParseQueryWithOptions(query, t.columns, options)
+}
+
+func (t *Table) MatchAll() *Query {
+	return MatchAll(t.columns, DefaultQueryOptions)
+}
+
+// Search returns the rows of the table matching the query, in file order
+// unless the query has an ORDER BY. When the indexes of the table can
+// narrow down the rows which may match, only those rows are read.
+func (t *Table) Search(query *Query) (*Rows, error) {
+	if query.Grouped() {
+		return nil, &SchemaError{Reason: "query groups its rows; read it with Aggregate"}
+	}
+	return t.scan(query)
+}
+
+// planSearch returns a reader over just the rows which the indexes of the
+// table say may match the query, along with the columns whose indexes it
+// used. It returns a nil reader if the query cannot be answered from the
+// indexes, so that every row must be read. The caller must hold a lock.
+func (t *Table) planSearch(f *os.File, query *Query) (recordReader, []string, error) {
+	var indexes map[int]*index = map[int]*index{}
+	defer func() {
+		for _, x := range indexes {
+			if x != nil {
+				x.close()
+			}
+		}
+	}()
+
+	if query.root == nil {
+		return nil, nil, nil
+	}
+
+	var used []string
+	matches, ok, err := t.planTree(query.root, query.options, indexes, &used)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+
+	var entries []indexEntry
+	for _, entry := range matches {
+		entries = append(entries, entry)
+	}
+
+	// Read the rows in file order, as a full scan would.
+	sort.Slice(entries, func(i int, j int) bool { return entries[i].location < entries[j].location })
+
+	var result *locatedReader = &locatedReader{file: f, filename: t.filename, h: t.header, entries: entries, reader: bufio.NewReader(f)}
+	return result, used, nil
+}
+
+// planTree returns the index entries, keyed by location, of every row which
+// may match the query, or false if that cannot be worked out from the
+// indexes.
+func (t *Table) planTree(root *queryNode, options QueryOptions, indexes map[int]*index, used *[]string) (map[int64]indexEntry, bool, error) {
+	if root.Kind == relation_node_kind || root.Kind == in_node_kind || root.Kind == between_node_kind {
+		return t.planRelation(root, options, indexes, used)
+	}
+
+	// The rows which do not match cannot be found from the indexes.
+	if root.Kind != and_node_kind && root.Kind != or_node_kind {
+		return nil, false, nil
+	}
+
+	left, left_ok, err := t.planTree(root.Left, options, indexes, used)
+	if err != nil {
+		return nil, false, err
+	}
+
+	right, right_ok, err := t.planTree(root.Right, options, indexes, used)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if root.Kind == and_node_kind {
+		if left_ok && right_ok {
+			var result map[int64]indexEntry = map[int64]indexEntry{}
+			for location, entry := range left {
+				if _, ok := right[location]; ok {
+					result[location] = entry
+				}
+			}
+			return result, true, nil
+		} else if left_ok {
+			return left, true, nil
+		}
+		return right, right_ok, nil
+	}
+
+	if !left_ok || !right_ok {
+		return nil, false, nil
+	}
+
+	for location, entry := range right {
+		left[location] = entry
+	}
+	return left, true, nil
+}
+
+// planRelation looks up a single relation, IN list or BETWEEN range in the
+// index on its column. String indexes are in byte order and so only serve
+// the binary collation and case-sensitive equality.
+func (t *Table) planRelation(relation *queryNode, options QueryOptions, indexes map[int]*index, used *[]string) (map[int64]indexEntry, bool, error) {
+	if relation.Left.Kind != column_node_kind || (relation.Kind == relation_node_kind && relation.Right.Kind != literal_node_kind) {
+		return nil, false, nil
+	}
+
+	var name string = relation.Left.Token.Value
+	var column int = t.columnIndex(name)
+	if column == -1 || !indexable(t.columns[column].Type) {
+		return nil, false, nil
+	}
+
+	if t.columns[column].Type == String {
+		var ordered bool = relation.Kind == between_node_kind || strings_contains(relation.Token.Value, []string{"<", "<=", ">", ">="}) != -1
+		if (ordered && options.Collation != BinaryCollation) || (!ordered && options.IgnoreCase) {
+			return nil, false, nil
+		}
+	}
+
+	x, opened := indexes[column]
+	if !opened {
+		var err error
+		x, err = t.openIndex(column, false)
+		if err != nil {
+			return nil, false, &IndexError{Column: name, Reason: err.Error()}
+		}
+		indexes[column] = x
+	}
+
+	if x == nil || !x.current(t, t.header) {
+		return nil, false, nil
+	}
+
+	var values []*queryNode = relation.Values
+	if relation.Kind == relation_node_kind {
+		values = []*queryNode{relation.Right}
+	}
+
+	var keys [][]byte
+	for i := range values {
+		key, err := literalKey(t.columns[column].Type, values[i].Token.Value)
+		if err != nil {
+			// Let the full scan report the bad value.
+			return nil, false, nil
+		}
+		keys = append(keys, key)
+	}
+
+	// Bounds are inclusive; Match rejects the rows which are not wanted.
+	var ranges [][2][]byte
+	if relation.Kind == in_node_kind {
+		for i := range keys {
+			ranges = append(ranges, [2][]byte{keys[i], keys[i]})
+		}
+	} else if relation.Kind == between_node_kind {
+		ranges = append(ranges, [2][]byte{keys[0], keys[1]})
+	} else {
+		switch relation.Token.Value {
+		case "=", "==":
+			ranges = append(ranges, [2][]byte{keys[0], keys[0]})
+		case ">", ">=":
+			ranges = append(ranges, [2][]byte{keys[0], nil})
+		case "<", "<=":
+			ranges = append(ranges, [2][]byte{nil, keys[0]})
+		default:
+			return nil, false, nil
+		}
+	}
+
+	var result map[int64]indexEntry = map[int64]indexEntry{}
+	for i := range ranges {
+		entries, err := x.lookup(ranges[i][0], ranges[i][1])
+		if err != nil {
+			return nil, false, &IndexError{Column: name, Reason: err.Error()}
+		}
+
+		for j := range entries {
+			result[entries[j].location] = entries[j]
+		}
+	}
+
+	if strings_contains(name, *used) == -1 {
+		*used = append(*used, name)
+	}
+
+	return result, true, nil
+}
+
+// literalKey returns the index key of a literal, which may be written in
+// any of the forms of number_pattern.
+func literalKey(column_type ColumnType, literal string) ([]byte, error) {
+	if column_type == Integer {
+		value, err := parseInteger(literal)
+		if err != nil {
+			return nil, err
+		}
+		return indexKey(column_type, strconv.Itoa(value))
+	} else if column_type == Double {
+		value, err := parseDouble(literal)
+		if err != nil {
+			return nil, err
+		}
+		return indexKey(column_type, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	return indexKey(column_type, literal)
+}
+
+// locatedReader reads the records at the locations of index entries.
+type locatedReader struct {
+	file     *os.File
+	filename string
+	h        header
+	entries  []indexEntry
+	position int
+	reader   *bufio.Reader
+	page     []byte
+	number   int
+}
+
+func (r *locatedReader) next() (int, bool, []string, error) {
+	if r.position >= len(r.entries) {
+		return -1, false, nil, io.EOF
+	}
+
+	var entry indexEntry = r.entries[r.position]
+	r.position += 1
+
+	var row_id int
+	var live bool
+	var values []string
+	var err error
+
+	if r.h.format == PageFormat {
+		number, slot := locationSlot(entry.location)
+		if r.page == nil || r.number != number {
+			r.page, err = readPage(r.file, number)
+			if err != nil {
+				return -1, false, nil, err
+			}
+			r.number = number
+		}
+
+		var record []byte
+		record, err = pageRecord(r.page, slot)
+		if err == nil {
+			row_id, live, values, err = decodeRecord(r.h.columns, record)
+		}
+	} else {
+		_, err = r.file.Seek(entry.location, io.SeekStart)
+		if err != nil {
+			return -1, false, nil, err
+		}
+		r.reader.Reset(r.file)
+
+		var line string
+		line, err = r.reader.ReadString('\n')
+		if err == nil {
+			row_id, live, values, err = parseRecord(strings.TrimSuffix(line, "\n"), len(r.h.columns), r.h.version)
+		}
+	}
+
+	if err == nil && row_id == -1 {
+		row_id = entry.rid
+	}
+
+	if err == nil && row_id != entry.rid {
+		err = errors.New("index is out of date: expected row id " + strconv.Itoa(entry.rid) + " but found " + strconv.Itoa(row_id))
+	}
+
+	if err != nil {
+		return -1, false, nil, &FormatError{Filename: r.filename, Line: 0, Reason: "record at offset " + strconv.FormatInt(entry.location, 10) + ": " + err.Error()}
+	}
+
+	return row_id, live, values, nil
+}
+
+func (r *locatedReader) location() int64 {
+	return r.entries[r.position-1].location
+}
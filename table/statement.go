@@ -0,0 +1,636 @@
+package table
+
+import (
+	"strconv"
+	"strings"
+)
+
+/**
+ * Statements are a subset of SQL, each standing for one of the operations
+ * on tables:
+ *
+ *      CREATE TABLE file "(" column type { "," column type } ")"
+ *                   [FORMAT (text | page)]
+ *      INSERT INTO file [ "(" column { "," column } ")" ]
+ *                   VALUES row { "," row }
+ *      SELECT ("*" | item { "," item }) FROM file [[AS] name]
+ *                   [[INNER | LEFT [OUTER]] JOIN file [[AS] name] ON condition]
+ *                   [WHERE query | clauses]
+ *      UPDATE file SET column "=" value { "," column "=" value } [WHERE query]
+ *      DELETE FROM file [WHERE query]
+ *
+ *      type        := integer | double | boolean | string | bigint
+ *                   | decimal "(" precision "," scale ")"
+ *      row         := "(" value { "," value } ")"
+ *      item        := column | aggregate
+ *      value       := number | string | bareword
+ *
+ * A file is a bareword, such as abc.tb, or a quoted path such as
+ * '../tables/abc.tb'. The query after WHERE is a query as taken by search,
+ * clauses and all, and a SELECT without WHERE may still end in clauses such
+ * as ORDER BY. Keywords ignore case, and a statement may end with `;`.
+ *
+ * ParseStatement only takes a statement apart: the conditions, the names of
+ * columns and the values are checked against the tables when it is run, as
+ * for the commands it stands for.
+**/
+type StatementKind int
+
+const (
+	CreateStatement StatementKind = iota
+	InsertStatement
+	SelectStatement
+	UpdateStatement
+	DeleteStatement
+)
+
+var statementKindNames map[StatementKind]string = map[StatementKind]string{CreateStatement: "CREATE TABLE", InsertStatement: "INSERT", SelectStatement: "SELECT", UpdateStatement: "UPDATE", DeleteStatement: "DELETE"}
+
+func (k StatementKind) String() string {
+	name, ok := statementKindNames[k]
+	if !ok {
+		return "unknown"
+	}
+	return name
+}
+
+/**
+ * A Statement is a parsed statement. Which fields are set depends on Kind:
+ *
+ *      CREATE TABLE:   Filename, Columns and Format.
+ *      INSERT:         Filename, Rows, and Names if the columns were listed.
+ *      SELECT:         Filename, Name, Selected (nil for *), Query, and Join
+ *                      if it joins a second table.
+ *      UPDATE:         Filename, Names and Values to set, and Query.
+ *      DELETE:         Filename and Query.
+ *
+ * Name is the name given with AS, empty for the default (see TableName).
+ * Query is the text after WHERE, or the clauses of a SELECT without WHERE,
+ * and is empty to match every row.
+**/
+type Statement struct {
+	Kind     StatementKind
+	Filename string
+	Name     string
+	Columns  []Column
+	Format   Format
+	Selected []string
+	Join     *StatementJoin
+	Query    string
+	Names    []string
+	Rows     [][]string
+	Values   []string
+}
+
+// A StatementJoin is the JOIN of a SELECT.
+type StatementJoin struct {
+	Kind     JoinKind
+	Filename string
+	Name     string
+	On       string
+}
+
+// IsStatement reports whether a line starts like a statement, rather than
+// like a command of pet of the same name: `DELETE FROM` rather than
+// `delete <rid> <file>`, and `CREATE TABLE file (...)` rather than
+// `create <file>` for a file named table.
+func IsStatement(line string) bool {
+	var words []string = strings.Fields(strings.ToUpper(line))
+	if len(words) < 2 {
+		return false
+	}
+
+	switch words[0] {
+	case "SELECT", "UPDATE":
+		return true
+	case "INSERT":
+		return words[1] == "INTO"
+	case "DELETE":
+		return words[1] == "FROM"
+	case "CREATE":
+		return words[1] == "TABLE" && strings.Contains(line, "(")
+	}
+	return false
+}
+
+type statementParser struct {
+	queryParser
+}
+
+func ParseStatement(text string) (*Statement, error) {
+	// A final `;` is left out of the text, so that offsets into it still
+	// hold.
+	var trimmed string = strings.TrimRight(text, " \t\n")
+	if strings.HasSuffix(trimmed, ";") {
+		text = trimmed[:len(trimmed)-1]
+	}
+
+	tokens, err := tokenizeQuery(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var p *statementParser = &statementParser{queryParser{query: text, tokens: tokens}}
+
+	var result *Statement
+	if p.keyword("CREATE") {
+		err = p.expect("TABLE")
+		if err == nil {
+			result, err = p.parseCreate()
+		}
+	} else if p.keyword("INSERT") {
+		err = p.expect("INTO")
+		if err == nil {
+			result, err = p.parseInsert()
+		}
+	} else if p.keyword("SELECT") {
+		result, err = p.parseSelect()
+	} else if p.keyword("UPDATE") {
+		result, err = p.parseUpdate()
+	} else if p.keyword("DELETE") {
+		err = p.expect("FROM")
+		if err == nil {
+			result, err = p.parseDelete()
+		}
+	} else {
+		return nil, p.expected("CREATE TABLE, INSERT, SELECT, UPDATE or DELETE")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// keyword moves past the next token if it is the given keyword.
+func (p *statementParser) keyword(word string) bool {
+	var next *token = p.peek()
+	if next == nil || next.Type != bareword_token_type || strings.ToUpper(next.Value) != word {
+		return false
+	}
+
+	p.position += 1
+	return true
+}
+
+func (p *statementParser) expect(word string) error {
+	if !p.keyword(word) {
+		return p.expected(word)
+	}
+	return nil
+}
+
+// punctuation moves past the next token if it is the given `(`, `)`, `,`,
+// `=` or `*`.
+func (p *statementParser) punctuation(value string) bool {
+	var next *token = p.peek()
+	if next == nil || next.Value != value || next.Type == bareword_token_type || next.Type == string_token_type {
+		return false
+	}
+
+	p.position += 1
+	return true
+}
+
+func (p *statementParser) expectPunctuation(value string) error {
+	if !p.punctuation(value) {
+		return p.expected("`" + value + "`")
+	}
+	return nil
+}
+
+// parseName parses a bareword, such as the name of a column.
+func (p *statementParser) parseName(what string) (string, error) {
+	var next *token = p.peek()
+	if next == nil || next.Type != bareword_token_type {
+		return "", p.expected(what)
+	}
+
+	p.position += 1
+	return next.Value, nil
+}
+
+// parseFile parses the filename of a table, bare or quoted.
+func (p *statementParser) parseFile() (string, error) {
+	var next *token = p.peek()
+	if next == nil || (next.Type != bareword_token_type && next.Type != string_token_type) {
+		return "", p.expected("a table file")
+	}
+
+	p.position += 1
+	return next.Value, nil
+}
+
+// parseValue parses a value to store: a number, a string, or a bareword
+// such as T.
+func (p *statementParser) parseValue() (string, error) {
+	var next *token = p.peek()
+	if next == nil || (next.Type != number_token_type && next.Type != string_token_type && next.Type != bareword_token_type) {
+		return "", p.expected("a value")
+	}
+
+	p.position += 1
+	return next.Value, nil
+}
+
+// parseNames parses a parenthesised list of names.
+func (p *statementParser) parseNames(what string) ([]string, error) {
+	err := p.expectPunctuation("(")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for {
+		name, err := p.parseName(what)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+
+		if !p.punctuation(",") {
+			return result, p.expectPunctuation(")")
+		}
+	}
+}
+
+// end fails unless the whole statement has been read.
+func (p *statementParser) end(what string) error {
+	if p.peek() != nil {
+		return p.expected(what)
+	}
+	return nil
+}
+
+func (p *statementParser) parseCreate() (*Statement, error) {
+	var result *Statement = &Statement{Kind: CreateStatement, Format: TextFormat}
+
+	var err error
+	result.Filename, err = p.parseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expectPunctuation("(")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		name, err := p.parseName("a column name")
+		if err != nil {
+			return nil, err
+		}
+
+		column, err := p.parseType(name)
+		if err != nil {
+			return nil, err
+		}
+		result.Columns = append(result.Columns, column)
+
+		if !p.punctuation(",") {
+			break
+		}
+	}
+
+	err = p.expectPunctuation(")")
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("FORMAT") {
+		var next *token = p.peek()
+		format, err := p.parseName("text or page")
+		if err != nil {
+			return nil, err
+		}
+
+		result.Format, err = ParseFormat(strings.ToLower(format))
+		if err != nil {
+			return nil, p.fail(next.Offset, err.Error())
+		}
+	}
+
+	return result, p.end("`,`, `)`, FORMAT or the end of the statement")
+}
+
+// parseType parses the type of a column, with the precision and scale of a
+// decimal.
+func (p *statementParser) parseType(name string) (Column, error) {
+	var result Column = Column{Name: name}
+
+	var next *token = p.peek()
+	type_name, err := p.parseName("the type of " + name)
+	if err != nil {
+		return result, err
+	}
+
+	for column_type, type_names := range columnTypeToName {
+		if strings.ToLower(type_name) == type_names {
+			result.Type = column_type
+		}
+	}
+
+	if !result.Type.Valid() {
+		return result, p.fail(next.Offset, "unknown type `"+type_name+"`; expected integer, double, boolean, string, bigint or decimal(precision, scale)")
+	} else if result.Type != Decimal {
+		return result, nil
+	}
+
+	err = p.expectPunctuation("(")
+	if err == nil {
+		result.Precision, err = p.parseCount("the precision of " + name)
+	}
+	if err == nil {
+		err = p.expectPunctuation(",")
+	}
+	if err == nil {
+		result.Scale, err = p.parseCount("the scale of " + name)
+	}
+	if err == nil {
+		err = p.expectPunctuation(")")
+	}
+	return result, err
+}
+
+// parseCount parses a whole number, such as the precision of a decimal.
+func (p *statementParser) parseCount(what string) (int, error) {
+	var next *token = p.peek()
+	if next == nil || next.Type != number_token_type {
+		return 0, p.expected(what)
+	}
+
+	result, err := strconv.Atoi(next.Value)
+	if err != nil {
+		return 0, p.fail(next.Offset, "expected "+what+" but found `"+next.Value+"`")
+	}
+
+	p.position += 1
+	return result, nil
+}
+
+func (p *statementParser) parseInsert() (*Statement, error) {
+	var result *Statement = &Statement{Kind: InsertStatement}
+
+	var err error
+	result.Filename, err = p.parseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next != nil && next.Value == "(" && next.Type == group_token_type {
+		result.Names, err = p.parseNames("a column name")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = p.expect("VALUES")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = p.expectPunctuation("(")
+		if err != nil {
+			return nil, err
+		}
+
+		var row []string
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+
+			if !p.punctuation(",") {
+				break
+			}
+		}
+
+		err = p.expectPunctuation(")")
+		if err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, row)
+
+		if !p.punctuation(",") {
+			break
+		}
+	}
+
+	return result, p.end("`,` or the end of the statement")
+}
+
+func (p *statementParser) parseSelect() (*Statement, error) {
+	var result *Statement = &Statement{Kind: SelectStatement}
+
+	if next := p.peek(); next != nil && next.Type == bareword_token_type && strings.ToUpper(next.Value) == "FROM" {
+		return nil, p.expected("`*`, a column or an aggregate")
+	} else if !p.punctuation("*") {
+		for {
+			name, err := p.parseName("a column or an aggregate")
+			if err != nil {
+				return nil, err
+			}
+			result.Selected = append(result.Selected, name)
+
+			if !p.punctuation(",") {
+				break
+			}
+		}
+	}
+
+	err := p.expect("FROM")
+	if err != nil {
+		return nil, err
+	}
+
+	result.Filename, result.Name, err = p.parseTable()
+	if err != nil {
+		return nil, err
+	}
+	var name *token = &p.tokens[p.position-1]
+
+	var kind JoinKind = InnerJoin
+	var joined bool = p.keyword("JOIN")
+	if !joined && p.keyword("INNER") {
+		joined, err = true, p.expect("JOIN")
+	} else if !joined && p.keyword("LEFT") {
+		p.keyword("OUTER")
+		joined, kind, err = true, LeftJoin, p.expect("JOIN")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if joined {
+		result.Join = &StatementJoin{Kind: kind}
+		result.Join.Filename, result.Join.Name, err = p.parseTable()
+		if err == nil {
+			err = p.expect("ON")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var start int = p.position
+		for p.peek() != nil && !p.startsWhere() {
+			p.position += 1
+		}
+		if start == p.position {
+			return nil, p.expected("a join condition")
+		}
+		result.Join.On = p.text(start, p.position)
+	} else if result.Name != "" {
+		return nil, p.fail(name.Offset, "only the tables of a join can be named")
+	}
+
+	result.Query, err = p.parseWhere(true)
+	return result, err
+}
+
+// parseTable parses a file to select from, and the name it is given.
+func (p *statementParser) parseTable() (string, string, error) {
+	filename, err := p.parseFile()
+	if err != nil {
+		return "", "", err
+	}
+
+	if p.keyword("AS") {
+		name, err := p.parseName("a name for " + filename)
+		return filename, name, err
+	}
+
+	// A bareword which does not start the rest of the statement names the
+	// table, as in `FROM abc.tb a`.
+	var next *token = p.peek()
+	if next != nil && next.Type == bareword_token_type && !p.startsWhere() && strings_contains(strings.ToUpper(next.Value), []string{"JOIN", "INNER", "LEFT", "ON"}) == -1 {
+		p.position += 1
+		return filename, next.Value, nil
+	}
+
+	return filename, "", nil
+}
+
+// startsWhere reports whether the next token starts WHERE or a clause of a
+// query.
+func (p *statementParser) startsWhere() bool {
+	var next *token = p.peek()
+	if next == nil || next.Type != bareword_token_type {
+		return false
+	} else if strings.ToUpper(next.Value) == "WHERE" {
+		return true
+	}
+
+	var rest queryParser = queryParser{query: p.query, tokens: p.tokens[p.position:]}
+	return rest.startsWithClause()
+}
+
+// parseWhere returns the query which ends a statement: the text after
+// WHERE or, if clauses may stand alone, from the first clause.
+func (p *statementParser) parseWhere(clauses bool) (string, error) {
+	if p.keyword("WHERE") {
+		if p.peek() == nil {
+			return "", p.expected("a condition")
+		}
+		return p.text(p.position, len(p.tokens)), nil
+	} else if clauses && p.startsWhere() {
+		return p.text(p.position, len(p.tokens)), nil
+	} else if clauses {
+		return "", p.end("WHERE, GROUP BY, HAVING, ORDER BY, LIMIT, OFFSET or the end of the statement")
+	}
+	return "", p.end("WHERE or the end of the statement")
+}
+
+// text returns the text of the statement from one token up to another.
+func (p *statementParser) text(start int, end int) string {
+	if start >= len(p.tokens) {
+		return ""
+	}
+
+	var last int = len(p.query)
+	if end < len(p.tokens) {
+		last = p.tokens[end].Offset
+	}
+	return strings.TrimSpace(p.query[p.tokens[start].Offset:last])
+}
+
+func (p *statementParser) parseUpdate() (*Statement, error) {
+	var result *Statement = &Statement{Kind: UpdateStatement}
+
+	var err error
+	result.Filename, err = p.parseFile()
+	if err == nil {
+		err = p.expect("SET")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		name, err := p.parseName("a column to set")
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.punctuation("=") && !p.punctuation("==") {
+			return nil, p.expected("`=`")
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		result.Names = append(result.Names, name)
+		result.Values = append(result.Values, value)
+
+		if !p.punctuation(",") {
+			break
+		}
+	}
+
+	result.Query, err = p.parseWhere(false)
+	return result, err
+}
+
+func (p *statementParser) parseDelete() (*Statement, error) {
+	var result *Statement = &Statement{Kind: DeleteStatement}
+
+	var err error
+	result.Filename, err = p.parseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	result.Query, err = p.parseWhere(false)
+	return result, err
+}
+
+// Row returns the values of a row to insert in the order of the columns of
+// the table, taking the names listed, if any, into account.
+func (s *Statement) Row(columns []Column, values []string) ([]string, error) {
+	if s.Names == nil {
+		if len(values) != len(columns) {
+			return nil, &SchemaError{Reason: "expected " + strconv.Itoa(len(columns)) + " values but got " + strconv.Itoa(len(values))}
+		}
+		return values, nil
+	}
+
+	positions, err := selectColumns(columns, s.Names)
+	if err != nil {
+		return nil, err
+	} else if len(positions) != len(columns) {
+		return nil, &SchemaError{Reason: "every attribute must be given a value; expected " + strconv.Itoa(len(columns)) + " attributes but got " + strconv.Itoa(len(positions))}
+	} else if len(values) != len(positions) {
+		return nil, &SchemaError{Reason: "expected " + strconv.Itoa(len(positions)) + " values but got " + strconv.Itoa(len(values))}
+	}
+
+	var result []string = make([]string, len(columns))
+	for i, position := range positions {
+		result[position] = values[i]
+	}
+	return result, nil
+}
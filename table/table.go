@@ -0,0 +1,395 @@
+package table
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Column types, as stored in the table header:
+ *      Integer:    1
+ *      Double:     2
+ *      Boolean:    3
+ *      String:     4
+ *      BigInteger: 5
+ *      Decimal:    6, followed by its precision and scale
+**/
+type ColumnType int
+
+const (
+	Integer    ColumnType = 1
+	Double     ColumnType = 2
+	Boolean    ColumnType = 3
+	String     ColumnType = 4
+	BigInteger ColumnType = 5
+	Decimal    ColumnType = 6
+)
+
+var columnTypeToName map[ColumnType]string = map[ColumnType]string{1: "integer", 2: "double", 3: "boolean", 4: "string", 5: "bigint", 6: "decimal"}
+
+func (c ColumnType) String() string {
+	name, ok := columnTypeToName[c]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+func (c ColumnType) Valid() bool {
+	_, ok := columnTypeToName[c]
+	return ok
+}
+
+// numeric reports whether values of the type compare as numbers.
+func (c ColumnType) numeric() bool {
+	return c == Integer || c == Double || c == BigInteger || c == Decimal
+}
+
+// Precision and Scale are the number of digits of a Decimal column in total
+// and after the point; they are zero for every other type.
+type Column struct {
+	Name      string
+	Type      ColumnType
+	Precision int
+	Scale     int
+}
+
+// TypeName returns the type of the column as shown to users, such as
+// `decimal(10,2)`.
+func (c Column) TypeName() string {
+	if c.Type == Decimal {
+		return "decimal(" + strconv.Itoa(c.Precision) + "," + strconv.Itoa(c.Scale) + ")"
+	}
+	return c.Type.String()
+}
+
+/**
+ * A Table is a handle on a single .tb file. Opening a table only reads the
+ * header (and, for files older than version 3, counts the records); rows are
+ * streamed from disk on demand via Rows, Row and Search.
+ *
+ * Every operation takes an advisory lock on the table (see lock.go) and
+ * re-reads the header, so several processes may share a table. A Table is
+ * not safe for concurrent use by multiple goroutines.
+**/
+type Table struct {
+	header
+	filename    string
+	warning     error
+	lockTimeout time.Duration
+}
+
+func ValidateColumnName(name string) error {
+	if len(name) == 0 {
+		return &SchemaError{Reason: "attribute name cannot be empty"}
+	}
+
+	if strings.ContainsAny(name, ":[]") {
+		return &SchemaError{Reason: "invalid character in attribute name `" + name + "`. Invalid characters are ':', '[', and ']'"}
+	}
+
+	return nil
+}
+
+// ValidateColumnType checks the type of a column, including the precision
+// and scale of a decimal.
+func ValidateColumnType(column Column) error {
+	if !column.Type.Valid() {
+		return &SchemaError{Reason: "unknown type for attribute `" + column.Name + "`"}
+	}
+
+	if column.Type != Decimal {
+		if column.Precision != 0 || column.Scale != 0 {
+			return &SchemaError{Reason: "attribute `" + column.Name + "` is not a decimal and cannot have a precision or scale"}
+		}
+		return nil
+	}
+
+	if column.Precision < 1 || column.Precision > max_decimal_precision {
+		return &SchemaError{Reason: "precision of decimal attribute `" + column.Name + "` must be from 1 to " + strconv.Itoa(max_decimal_precision)}
+	}
+
+	if column.Scale < 0 || column.Scale > column.Precision {
+		return &SchemaError{Reason: "scale of decimal attribute `" + column.Name + "` must be from 0 to its precision, " + strconv.Itoa(column.Precision)}
+	}
+
+	return nil
+}
+
+func Create(filename string, columns []Column) (*Table, error) {
+	return CreateWithFormat(filename, columns, TextFormat)
+}
+
+// CreateWithFormat creates a table stored in the given format; see format.go
+// for the text format and page.go for the page format. Both are read and
+// written through the same methods.
+func CreateWithFormat(filename string, columns []Column, format Format) (*Table, error) {
+	if _, ok := formatNames[format]; !ok {
+		return nil, &SchemaError{Reason: "unknown table format"}
+	}
+
+	if len(columns) == 0 {
+		return nil, &SchemaError{Reason: "a table needs at least one attribute"}
+	}
+
+	for i := range columns {
+		err := ValidateColumnName(columns[i].Name)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ValidateColumnType(columns[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < i; j++ {
+			if columns[j].Name == columns[i].Name {
+				return nil, &SchemaError{Reason: "attribute name `" + columns[i].Name + "` already in use"}
+			}
+		}
+	}
+
+	if _, err := os.Stat(filename); err == nil {
+		return nil, ErrExist
+	}
+
+	var result *Table = &Table{filename: filename, lockTimeout: DefaultLockTimeout}
+
+	l, err := result.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	// A journal or indexes left behind by a table that no longer exists
+	// are stale.
+	os.Remove(result.journalName())
+	for i := range columns {
+		os.Remove(result.indexName(i))
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrExist
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var h header = header{version: currentVersion, columns: columns, records: 0}
+	if format == PageFormat {
+		h, err = createPages(f, h)
+	} else {
+		h.size = int64(len(formatHeader(h)) + 1)
+		_, err = f.Write([]byte(formatHeader(h) + "\n"))
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+
+	result.header = h
+	return result, nil
+}
+
+func Open(filename string) (*Table, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+
+	var result *Table = &Table{filename: filename, lockTimeout: DefaultLockTimeout}
+
+	l, err := result.acquire(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	return result, nil
+}
+
+// refresh re-reads the header from disk, picking up changes made by other
+// processes. The caller must hold a lock on the table.
+func (t *Table) refresh() error {
+	f, err := os.Open(t.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotExist
+		}
+		return err
+	}
+	defer f.Close()
+
+	is_page, err := isPageFile(f)
+	if err != nil {
+		return err
+	}
+
+	if is_page {
+		return t.refreshPages(f)
+	}
+
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		if s.Err() != nil {
+			return s.Err()
+		}
+		return &FormatError{Filename: t.filename, Line: 0, Reason: "empty file"}
+	}
+
+	h, err := parseHeader(s.Text())
+	if err != nil {
+		return &FormatError{Filename: t.filename, Line: 0, Reason: err.Error()}
+	}
+
+	var warning error
+
+	if h.version >= 3 {
+		// The header records the committed size of the file, so there is
+		// no need to count the records.
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		if info.Size() < h.size {
+			return &FormatError{Filename: t.filename, Line: 0, Reason: "file is shorter than the size recorded in its header: " + strconv.FormatInt(info.Size(), 10) + " < " + strconv.FormatInt(h.size, 10)}
+		} else if info.Size() > h.size {
+			warning = &UncommittedError{Bytes: info.Size() - h.size}
+		}
+	} else {
+		var lines int = 0
+		for s.Scan() {
+			lines += 1
+		}
+
+		if s.Err() != nil {
+			return s.Err()
+		}
+
+		if lines != h.records {
+			warning = &CountError{Header: h.records, Actual: lines}
+			h.records = lines
+		}
+	}
+
+	t.header = h
+	t.warning = warning
+	return nil
+}
+
+func (t *Table) refreshPages(f *os.File) error {
+	page, err := readPage(f, 0)
+	if err != nil {
+		return &FormatError{Filename: t.filename, Line: 0, Reason: "cannot read header page: " + err.Error()}
+	}
+
+	h, err := decodePageHeader(page)
+	if err != nil {
+		return &FormatError{Filename: t.filename, Line: 0, Reason: err.Error()}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var warning error
+	if info.Size() < h.size {
+		return &FormatError{Filename: t.filename, Line: 0, Reason: "file is shorter than the pages recorded in its header: " + strconv.FormatInt(info.Size(), 10) + " < " + strconv.FormatInt(h.size, 10)}
+	} else if info.Size() > h.size {
+		warning = &UncommittedError{Bytes: info.Size() - h.size}
+	}
+
+	t.header = h
+	t.warning = warning
+	return nil
+}
+
+func (t *Table) Filename() string {
+	return t.filename
+}
+
+func (t *Table) Schema() []Column {
+	var result []Column = make([]Column, len(t.columns))
+	copy(result, t.columns)
+	return result
+}
+
+// SelectColumns looks up the named columns, such as the columns a search
+// should show, and returns their positions in the order they were named.
+func (t *Table) SelectColumns(names []string) ([]int, error) {
+	return selectColumns(t.columns, names)
+}
+
+func selectColumns(columns []Column, names []string) ([]int, error) {
+	if len(names) == 0 {
+		return nil, &SchemaError{Reason: "no attributes selected"}
+	}
+
+	var result []int
+	for i := range names {
+		var position int = -1
+		for j := range columns {
+			if columns[j].Name == names[i] {
+				position = j
+			}
+		}
+
+		if position == -1 {
+			var known []string
+			for j := range columns {
+				known = append(known, columns[j].Name)
+			}
+			return nil, &SchemaError{Reason: "unknown attribute `" + names[i] + "`; expected one of " + strings.Join(known, ", ")}
+		}
+
+		for j := range result {
+			if result[j] == position {
+				return nil, &SchemaError{Reason: "attribute `" + names[i] + "` is selected twice"}
+			}
+		}
+
+		result = append(result, position)
+	}
+
+	return result, nil
+}
+
+// Format returns the storage format of the table.
+func (t *Table) Format() Format {
+	return t.format
+}
+
+func (t *Table) Count() int {
+	return t.records
+}
+
+// SetLockTimeout sets how long operations on this table wait for another
+// process to release its lock before failing with a LockError.
+func (t *Table) SetLockTimeout(timeout time.Duration) {
+	t.lockTimeout = timeout
+}
+
+// Warning returns a recoverable problem found while opening the table, such
+// as a header record count that disagrees with the file contents.
+func (t *Table) Warning() error {
+	return t.warning
+}
+
+func (t *Table) columnIndex(name string) int {
+	for i := range t.columns {
+		if t.columns[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
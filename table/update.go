@@ -0,0 +1,145 @@
+package table
+
+import (
+	"strconv"
+)
+
+// Update replaces the values of the record with the given row id, which
+// keeps its row id. The old record and its replacement change in a single
+// journaled mutation, so a crash leaves one or the other.
+func (t *Table) Update(row_id int, values []string) error {
+	if len(values) != len(t.columns) {
+		return &SchemaError{Reason: "expected " + strconv.Itoa(len(t.columns)) + " values but got " + strconv.Itoa(len(values))}
+	}
+
+	l, err := t.acquire(true)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+
+	var record_data []string
+	for i := range t.columns {
+		value, err := ParseValue(t.columns[i], values[i])
+		if err != nil {
+			return err
+		}
+
+		record_data = append(record_data, value)
+	}
+
+	var before header = t.header
+
+	if t.format == PageFormat {
+		old_location, old_values, location, err := t.updatePages(row_id, record_data)
+		if err != nil {
+			return err
+		}
+
+		return t.replaceIndexes(before, row_id, old_values, old_location, record_data, location)
+	}
+
+	if t.version == currentVersion {
+		offset, old_line, err := findRecord(t.filename, t.header, row_id)
+		if err != nil {
+			return err
+		}
+
+		_, _, old_values, err := parseRecord(old_line, len(t.columns), t.version)
+		if err != nil {
+			return err
+		}
+
+		// The new record is appended under the same row id and the old one
+		// is tombstoned; the record count does not change.
+		var line string = formatRecord(row_id, record_data)
+		var after header = t.header
+		after.size += int64(len(line) + 1)
+
+		err = t.commit(&journal{op: "update", before: formatHeader(t.header), after: formatHeader(after), rid: row_id, offset: offset, row: line, replaced: old_line})
+		if err != nil {
+			return err
+		}
+
+		return t.replaceIndexes(before, row_id, old_values, offset, record_data, before.size)
+	}
+
+	// Older formats are upgraded with a full rewrite, in which the row id
+	// of every record is its position.
+	before_line, err := readHeaderLine(t.filename)
+	if err != nil {
+		return err
+	}
+
+	lines, err := readLines(t.filename, t.header)
+	if err != nil {
+		return err
+	}
+
+	if row_id < 0 || row_id >= len(lines) {
+		return &NotFoundError{RID: row_id}
+	}
+
+	var old_line string = lines[row_id]
+	lines[row_id] = formatRecord(row_id, record_data)
+
+	var after header = rewriteHeader(t.header, lines)
+	after.next_rid = len(lines)
+
+	err = t.commit(&journal{op: "replace", before: before_line, after: formatHeader(after), rid: row_id, row: lines[row_id], replaced: old_line})
+	if err != nil {
+		return err
+	}
+
+	// The rewrite moved every record, so the indexes are rebuilt.
+	return t.replaceIndexes(before, row_id, nil, -1, nil, -1)
+}
+
+// UpdateWhere sets the columns at the given positions, as returned by
+// SelectColumns, to values in every row matching the query, and returns
+// how many rows it updated. The rows are found and updated under one
+// exclusive lock, in a single journaled mutation (see change.go), and keep
+// their row ids.
+func (t *Table) UpdateWhere(query *Query, positions []int, values []string) (int, error) {
+	if len(values) != len(positions) {
+		return 0, &SchemaError{Reason: "expected " + strconv.Itoa(len(positions)) + " values but got " + strconv.Itoa(len(values))}
+	}
+
+	l, err := t.acquire(true)
+	if err != nil {
+		return 0, err
+	}
+	defer l.release()
+
+	var record_data []string
+	for i, position := range positions {
+		if position < 0 || position >= len(t.columns) {
+			return 0, &SchemaError{Reason: "no attribute at position " + strconv.Itoa(position)}
+		}
+
+		value, err := ParseValue(t.columns[position], values[i])
+		if err != nil {
+			return 0, err
+		}
+
+		record_data = append(record_data, value)
+	}
+
+	rows, err := t.matchingRows(query)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range rows {
+		for j, position := range positions {
+			rows[i].Values[position] = record_data[j]
+		}
+	}
+
+	err = t.changeRows(nil, rows, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rows), nil
+}
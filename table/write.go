@@ -0,0 +1,398 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+/**
+ * Rewrites never modify the table file in place. The new contents are
+ * written to <filename>.tmp, synced to disk, and renamed over the original,
+ * so that a crash at any point leaves either the old or the new table.
+ *
+ * Steps, in order:
+ *      StepCreate:     create the temporary file
+ *      StepWrite:      write one line (the header, then each record)
+ *      StepSync:       fsync the temporary file
+ *      StepRename:     rename the temporary file over the table
+ *      StepSyncDir:    fsync the directory holding the table
+ *
+ * Inserts into a current table are appended instead (see appendLine),
+ * deletes flip the status of their record (see tombstoneLine), and updates
+ * do both (see updateLine), as does a statement changing several rows at
+ * once (see changeLines); they use StepWrite and StepSync for each record
+ * and then again for the header.
+ *
+ * Every mutation is first recorded in the journal (see journal.go):
+ *      StepJournal:        write the journal
+ *      StepJournalSync:    fsync the journal
+ *      StepRemoveJournal:  remove the journal once the table is updated
+**/
+type WriteStep int
+
+const (
+	StepCreate WriteStep = iota
+	StepWrite
+	StepSync
+	StepRename
+	StepSyncDir
+	StepJournal
+	StepJournalSync
+	StepRemoveJournal
+)
+
+var writeStepNames map[WriteStep]string = map[WriteStep]string{StepCreate: "create", StepWrite: "write", StepSync: "sync", StepRename: "rename", StepSyncDir: "sync directory", StepJournal: "journal", StepJournalSync: "sync journal", StepRemoveJournal: "remove journal"}
+
+func (s WriteStep) String() string {
+	return writeStepNames[s]
+}
+
+// WriteHook, when set, is called before each step of a rewrite. Returning an
+// error aborts the rewrite at that step; panicking simulates a crash. It
+// exists for failure injection (see atomic_testing) and is nil otherwise.
+var WriteHook func(step WriteStep) error
+
+func writeStep(step WriteStep) error {
+	if WriteHook == nil {
+		return nil
+	}
+
+	return WriteHook(step)
+}
+
+// rewriteHeader returns the header of a table holding exactly these lines.
+// The next row id is left for the caller to set.
+func rewriteHeader(h header, lines []string) header {
+	h.version = currentVersion
+	h.records = 0
+	h.size = int64(len(formatHeader(h)) + 1)
+	for i := range lines {
+		if lines[i][0] == '+' {
+			h.records += 1
+		}
+		h.size += int64(len(lines[i]) + 1)
+	}
+
+	return h
+}
+
+// appendHeader returns the header of a table after appending this line.
+func appendHeader(h header, line string) header {
+	h.records += 1
+	h.size += int64(len(line) + 1)
+	h.next_rid += 1
+	return h
+}
+
+// writeLines atomically replaces the table file with the given header and
+// record lines, returning the header as written.
+func writeLines(filename string, h header, lines []string) (header, error) {
+	var temp_name string = filename + ".tmp"
+
+	h = rewriteHeader(h, lines)
+
+	err := writeStep(StepCreate)
+	if err != nil {
+		return h, err
+	}
+
+	fw, err := os.OpenFile(temp_name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return h, err
+	}
+
+	err = writeTempLines(fw, formatHeader(h), lines)
+
+	if err == nil {
+		err = writeStep(StepSync)
+	}
+
+	if err == nil {
+		err = fw.Sync()
+	}
+
+	var close_err error = fw.Close()
+	if err == nil {
+		err = close_err
+	}
+
+	if err == nil {
+		err = writeStep(StepRename)
+	}
+
+	if err == nil {
+		err = os.Rename(temp_name, filename)
+	}
+
+	if err != nil {
+		os.Remove(temp_name)
+		return h, err
+	}
+
+	err = writeStep(StepSyncDir)
+	if err != nil {
+		return h, err
+	}
+
+	return h, syncDir(filepath.Dir(filename))
+}
+
+// appendLine adds a single record line to a table of version 3 or later and
+// then updates the record count and size in the header in place. The header
+// size is the commit point: until it is rewritten, the new line lies past
+// the recorded size and readers ignore it.
+func appendLine(filename string, h header, line string) (header, error) {
+	var old_header string = formatHeader(h)
+
+	fw, err := os.OpenFile(filename, os.O_RDWR, 0666)
+	if err != nil {
+		return h, err
+	}
+	defer fw.Close()
+
+	err = writeStep(StepWrite)
+	if err != nil {
+		return h, err
+	}
+
+	_, err = fw.WriteAt([]byte(line+"\n"), h.size)
+	if err != nil {
+		return h, err
+	}
+
+	var result header = appendHeader(h, line)
+
+	// Drop any uncommitted data left behind by an earlier failed append.
+	err = fw.Truncate(result.size)
+	if err != nil {
+		return h, err
+	}
+
+	err = writeStep(StepSync)
+	if err != nil {
+		return h, err
+	}
+
+	err = fw.Sync()
+	if err != nil {
+		return h, err
+	}
+
+	err = updateHeader(fw, old_header, formatHeader(result))
+	if err != nil {
+		return h, err
+	}
+
+	return result, nil
+}
+
+// tombstoneLine marks the record line at offset as deleted by flipping its
+// status in place, and then updates the header in place.
+func tombstoneLine(filename string, before header, after header, offset int64, line string) error {
+	fw, err := os.OpenFile(filename, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	err = markDeleted(fw, offset, line)
+	if err != nil {
+		return err
+	}
+
+	err = writeStep(StepSync)
+	if err != nil {
+		return err
+	}
+
+	err = fw.Sync()
+	if err != nil {
+		return err
+	}
+
+	return updateHeader(fw, formatHeader(before), formatHeader(after))
+}
+
+// markDeleted flips the status of the record line at offset, which may
+// already be deleted by an earlier attempt, without syncing.
+func markDeleted(fw *os.File, offset int64, line string) error {
+	var current []byte = make([]byte, len(line))
+	_, err := fw.ReadAt(current, offset)
+	if err != nil {
+		return err
+	}
+
+	if string(current[1:]) != line[1:] || (current[0] != '+' && current[0] != '-') {
+		return errors.New("record at offset " + strconv.FormatInt(offset, 10) + " does not match")
+	}
+
+	err = writeStep(StepWrite)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.WriteAt([]byte("-"), offset)
+	return err
+}
+
+// updateLine writes the new record line of an update past the committed
+// end of the table, and then tombstones the old line at offset, which also
+// updates the header. Until the header is rewritten, readers ignore the new
+// line and a redo simply writes it again.
+func updateLine(filename string, before header, after header, offset int64, old_line string, line string) error {
+	fw, err := os.OpenFile(filename, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	err = writeStep(StepWrite)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.WriteAt([]byte(line+"\n"), before.size)
+	if err != nil {
+		return err
+	}
+
+	err = fw.Truncate(after.size)
+	if err != nil {
+		return err
+	}
+
+	err = writeStep(StepSync)
+	if err != nil {
+		return err
+	}
+
+	err = fw.Sync()
+	if err != nil {
+		return err
+	}
+
+	return tombstoneLine(filename, before, after, offset, old_line)
+}
+
+// changeLines appends record lines past the committed end of the table,
+// then tombstones the records at the given offsets, and finally updates the
+// header. As with updateLine, the header is the commit point and a redo
+// simply writes everything again.
+func changeLines(filename string, before header, after header, lines []string, tombstones []tombstone) error {
+	fw, err := os.OpenFile(filename, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	var offset int64 = before.size
+	for i := range lines {
+		err = writeStep(StepWrite)
+		if err != nil {
+			return err
+		}
+
+		_, err = fw.WriteAt([]byte(lines[i]+"\n"), offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(len(lines[i]) + 1)
+	}
+
+	err = fw.Truncate(after.size)
+	if err != nil {
+		return err
+	}
+
+	err = writeStep(StepSync)
+	if err != nil {
+		return err
+	}
+
+	err = fw.Sync()
+	if err != nil {
+		return err
+	}
+
+	for i := range tombstones {
+		err = markDeleted(fw, tombstones[i].offset, tombstones[i].line)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeStep(StepSync)
+	if err != nil {
+		return err
+	}
+
+	err = fw.Sync()
+	if err != nil {
+		return err
+	}
+
+	return updateHeader(fw, formatHeader(before), formatHeader(after))
+}
+
+// updateHeader overwrites a fixed-width header in place and syncs it.
+func updateHeader(fw *os.File, old_header string, new_header string) error {
+	if len(new_header) != len(old_header) {
+		return errors.New("header changed length; cannot update in place")
+	}
+
+	err := writeStep(StepWrite)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.WriteAt([]byte(new_header), 0)
+	if err != nil {
+		return err
+	}
+
+	err = writeStep(StepSync)
+	if err != nil {
+		return err
+	}
+
+	return fw.Sync()
+}
+
+func writeTempLines(fw *os.File, header string, lines []string) error {
+	err := writeStep(StepWrite)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.Write([]byte(header + "\n"))
+	if err != nil {
+		return err
+	}
+
+	for i := range lines {
+		err = writeStep(StepWrite)
+		if err != nil {
+			return err
+		}
+
+		_, err = fw.Write([]byte(lines[i] + "\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncDir flushes a directory entry change, such as a rename, to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}